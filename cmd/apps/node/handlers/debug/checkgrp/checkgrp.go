@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
 	"go.uber.org/zap"
 )
 
@@ -12,15 +13,23 @@ import (
 type Handlers struct {
 	Build string
 	Log   *zap.SugaredLogger
+	State *state.State
 }
 
 // Readiness checks if the database is ready and if not will return a 500 status.
+// The node isn't ready until it has synced the blockchain with its peers at
+// startup.
 // Do not respond by just returning an error because further up in the call
 // stack it will interpret that as a non-trusted error.
 func (h Handlers) Readiness(w http.ResponseWriter, r *http.Request) {
 	status := "ok"
 	statusCode := http.StatusOK
 
+	if !h.State.IsSynced() {
+		status = "syncing"
+		statusCode = http.StatusInternalServerError
+	}
+
 	data := struct {
 		Status string `json:"status"`
 	}{
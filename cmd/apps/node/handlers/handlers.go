@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sphierex/blockchain/cmd/apps/node/handlers/debug/checkgrp"
 	v1 "github.com/sphierex/blockchain/cmd/apps/node/handlers/v1"
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
 	"go.uber.org/zap"
 )
 
@@ -21,6 +22,7 @@ func init() {
 type MuxConfig struct {
 	Shutdown chan os.Signal
 	Log      *zap.SugaredLogger
+	State    *state.State
 }
 
 // PublicMux constructs a http.Handler with all application routes defined.
@@ -33,7 +35,10 @@ func PublicMux(cfg MuxConfig) http.Handler {
 		gin.Recovery(),
 	)
 
-	v1.PublicRoutes(app, v1.Config{Log: cfg.Log})
+	v1.PublicRoutes(app, v1.Config{
+		Log:   cfg.Log,
+		State: cfg.State,
+	})
 
 	return app
 }
@@ -48,7 +53,10 @@ func PrivateMux(cfg MuxConfig) http.Handler {
 		gin.Recovery(),
 	)
 
-	v1.PrivateRoutes(app, v1.Config{Log: cfg.Log})
+	v1.PrivateRoutes(app, v1.Config{
+		Log:   cfg.Log,
+		State: cfg.State,
+	})
 
 	return app
 }
@@ -74,12 +82,13 @@ func standardDebugMux() *http.ServeMux {
 // debug application routes for the service. This bypassing the use of the
 // DefaultServerMux. Using the DefaultServerMux would be a security risk since
 // a dependency could inject a handler into our service without us knowing it.
-func DebugMux(build string, log *zap.SugaredLogger) http.Handler {
+func DebugMux(build string, log *zap.SugaredLogger, st *state.State) http.Handler {
 	mux := standardDebugMux()
 
 	cgh := checkgrp.Handlers{
 		Build: build,
 		Log:   log,
+		State: st,
 	}
 	mux.HandleFunc("/debug/readiness", cgh.Readiness)
 	mux.HandleFunc("/debug/liveness", cgh.Liveness)
@@ -1,15 +1,28 @@
 package private
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sphierex/blockchain/internal/web/errs"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/mempool"
+	"github.com/sphierex/blockchain/pkg/blockchain/peer"
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
 	"go.uber.org/zap"
 )
 
+// maxBlockRange is the largest number of blocks returned by a single
+// block list request.
+const maxBlockRange = 100
+
 // Handlers manages the set of bar ledger endpoints.
 type Handlers struct {
-	Log *zap.SugaredLogger
+	Log   *zap.SugaredLogger
+	State *state.State
 }
 
 // Sample just provides a starting point for the class.
@@ -22,3 +35,158 @@ func (h Handlers) Sample(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, resp)
 }
+
+// Status returns the current status of the node.
+func (h Handlers) Status(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.State.Status())
+}
+
+// SubmitPeer is called by a node so it can be added to the known peer list.
+func (h Handlers) SubmitPeer(ctx *gin.Context) {
+	var pr peer.Peer
+	if err := ctx.ShouldBindJSON(&pr); err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(fmt.Errorf("unable to decode payload: %w", err)))
+		return
+	}
+
+	if pr.Host == "" {
+		err := errors.New("missing peer host")
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	added, err := h.State.AddKnownPeer(pr)
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusServiceUnavailable, errs.New(err))
+		return
+	}
+
+	status := "peer already known"
+	if added {
+		h.Log.Infow("adding peer", "traceid", ctx.GetString("tradeId"), "host", pr.Host)
+		status = "peer added"
+	}
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: status,
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// ProposeBlock takes a block mined by a peer and applies it to the chain
+// when it follows on from the latest block. When it doesn't, the node syncs
+// with the peer named in the host header.
+func (h Handlers) ProposeBlock(ctx *gin.Context) {
+	var blockData database.BlockData
+	if err := ctx.ShouldBindJSON(&blockData); err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(fmt.Errorf("unable to decode payload: %w", err)))
+		return
+	}
+
+	block, err := database.ToBlock(blockData)
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(fmt.Errorf("unable to decode block: %w", err)))
+		return
+	}
+
+	pr := peer.New(ctx.GetHeader(peer.HostHeader))
+
+	if err := h.State.ProcessProposedBlock(pr, block); err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusNotAcceptable, errs.New(err))
+		return
+	}
+
+	h.Log.Infow("block accepted", "traceid", ctx.GetString("tradeId"), "block", block.Header.Number, "hash", block.Hash())
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "accepted",
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// SubmitNodeTransactions adds a batch of transactions shared by a peer to
+// the mempool. Transactions this node already knows about are skipped.
+func (h Handlers) SubmitNodeTransactions(ctx *gin.Context) {
+	var trans []database.BlockTx
+	if err := ctx.ShouldBindJSON(&trans); err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(fmt.Errorf("unable to decode payload: %w", err)))
+		return
+	}
+
+	var accepted int
+	for _, tx := range trans {
+		if err := h.State.UpsertNodeTransaction(tx); err != nil {
+			if !errors.Is(err, mempool.ErrAlreadyKnown) {
+				h.Log.Infow("rejected node tran", "traceid", ctx.GetString("tradeId"), "sig:nonce", tx, "ERROR", err)
+			}
+			continue
+		}
+		accepted++
+	}
+
+	h.Log.Infow("add node trans", "traceid", ctx.GetString("tradeId"), "trans", len(trans), "accepted", accepted)
+
+	resp := struct {
+		Status   string `json:"status"`
+		Accepted int    `json:"accepted"`
+	}{
+		Status:   "transactions processed",
+		Accepted: accepted,
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// BlocksByRange returns the blocks in the specified inclusive range so a
+// peer can sync its blockchain. The range is cut short at the latest block.
+func (h Handlers) BlocksByRange(ctx *gin.Context) {
+	from, err := strconv.ParseUint(ctx.Param("from"), 10, 64)
+	if err != nil {
+		err = fmt.Errorf("invalid block number %q", ctx.Param("from"))
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	to, err := strconv.ParseUint(ctx.Param("to"), 10, 64)
+	if err != nil {
+		err = fmt.Errorf("invalid block number %q", ctx.Param("to"))
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	switch {
+	case from > to:
+		err = fmt.Errorf("invalid range, from %d is after to %d", from, to)
+	case to-from >= maxBlockRange:
+		err = fmt.Errorf("invalid range, no more than %d blocks can be requested", maxBlockRange)
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	blocks, err := h.State.QueryBlocks(from, to)
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errs.New(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, blocks)
+}
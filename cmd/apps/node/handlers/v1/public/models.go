@@ -0,0 +1,73 @@
+package public
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/merkle"
+)
+
+// submitResponse is returned once a wallet transaction has been accepted
+// into the mempool.
+type submitResponse struct {
+	Status string `json:"status"`
+	Hash   string `json:"hash"`
+}
+
+// account is the balance and nonce of a single account.
+type account struct {
+	AccountID database.AccountID `json:"account"`
+	Nonce     uint64             `json:"nonce"`
+	Balance   uint64             `json:"balance"`
+}
+
+// accountsResponse is returned for account queries, along with the latest
+// block and state root the accounts were read at.
+type accountsResponse struct {
+	LatestBlock uint64    `json:"latest_block"`
+	StateRoot   string    `json:"state_root"`
+	Accounts    []account `json:"accounts"`
+}
+
+// accountProofResponse is the proof that an account is or is not part of the
+// state root of the latest block. The value hash in the proof is the sha256
+// of the nonce and balance as 8 byte big endian values.
+type accountProofResponse struct {
+	LatestBlock uint64             `json:"latest_block"`
+	StateRoot   string             `json:"state_root"`
+	Account     *account           `json:"account,omitempty"`
+	Proof       merkle.SparseProof `json:"proof"`
+}
+
+func toAccount(acct database.Account) account {
+	return account{
+		AccountID: acct.AccountID,
+		Nonce:     acct.Nonce,
+		Balance:   acct.Balance,
+	}
+}
+
+// txProofResponse is the merkle proof that a transaction is part of a block.
+type txProofResponse struct {
+	Block     uint64           `json:"block"`
+	Tx        database.BlockTx `json:"tx"`
+	LeafHash  string           `json:"leaf_hash"`
+	Proof     []string         `json:"proof"`
+	Order     []int64          `json:"order"`
+	TransRoot string           `json:"trans_root"`
+}
+
+func toTxProofResponse(num uint64, txProof database.TxProof) txProofResponse {
+	proof := make([]string, len(txProof.Proof))
+	for i, hash := range txProof.Proof {
+		proof[i] = hexutil.Encode(hash)
+	}
+
+	return txProofResponse{
+		Block:     num,
+		Tx:        txProof.Tx,
+		LeafHash:  hexutil.Encode(txProof.LeafHash),
+		Proof:     proof,
+		Order:     txProof.Order,
+		TransRoot: txProof.TransRoot,
+	}
+}
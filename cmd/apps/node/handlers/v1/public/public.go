@@ -1,15 +1,28 @@
 package public
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gin-gonic/gin"
+	"github.com/sphierex/blockchain/internal/web/errs"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
 	"go.uber.org/zap"
 )
 
+// maxBlockRange is the largest number of blocks returned by a single
+// block list request.
+const maxBlockRange = 100
+
 // Handlers manages the set of bar ledger endpoints.
 type Handlers struct {
-	Log *zap.SugaredLogger
+	Log   *zap.SugaredLogger
+	State *state.State
 }
 
 // Sample just provides a starting point for the class.
@@ -22,3 +35,234 @@ func (h Handlers) Sample(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, resp)
 }
+
+// SubmitWalletTransaction adds new transactions to the mempool.
+func (h Handlers) SubmitWalletTransaction(ctx *gin.Context) {
+	var signedTx database.SignedTx
+	if err := ctx.ShouldBindJSON(&signedTx); err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(fmt.Errorf("unable to decode payload: %w", err)))
+		return
+	}
+
+	tx, err := h.State.UpsertWalletTransaction(signedTx)
+	if err != nil {
+		_ = ctx.Error(err)
+
+		resp := errs.New(err)
+		resp.Fields = map[string]string{
+			"to":    string(signedTx.ToID),
+			"nonce": strconv.FormatUint(signedTx.Nonce, 10),
+		}
+
+		ctx.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	h.Log.Infow("add tran", "traceid", ctx.GetString("tradeId"), "sig:nonce", tx, "to", tx.ToID, "value", tx.Value, "tip", tx.Tip)
+
+	hash, err := tx.Hash()
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errs.New(err))
+		return
+	}
+
+	resp := submitResponse{
+		Status: "transaction added to mempool",
+		Hash:   hexutil.Encode(hash),
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Accounts returns the current balances for all accounts.
+func (h Handlers) Accounts(ctx *gin.Context) {
+	snapshot := h.State.Accounts()
+
+	resp := accountsResponse{
+		LatestBlock: snapshot.Latest.Header.Number,
+		StateRoot:   snapshot.StateRoot,
+		Accounts:    make([]account, 0, len(snapshot.Accounts)),
+	}
+	for _, acct := range snapshot.Accounts {
+		resp.Accounts = append(resp.Accounts, toAccount(acct))
+	}
+	sort.Slice(resp.Accounts, func(i, j int) bool {
+		return resp.Accounts[i].AccountID < resp.Accounts[j].AccountID
+	})
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Account returns the current balance and nonce for the specified account.
+func (h Handlers) Account(ctx *gin.Context) {
+	accountID, err := database.ToAccountID(ctx.Param("account"))
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	snapshot := h.State.Accounts()
+
+	acct, err := snapshot.Query(accountID)
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, errs.New(err))
+		return
+	}
+
+	resp := accountsResponse{
+		LatestBlock: snapshot.Latest.Header.Number,
+		StateRoot:   snapshot.StateRoot,
+		Accounts:    []account{toAccount(acct)},
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// AccountProof returns the proof that the account is or is not part of the
+// state root of the latest block.
+func (h Handlers) AccountProof(ctx *gin.Context) {
+	accountID, err := database.ToAccountID(ctx.Param("account"))
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	snapshot, proof, err := h.State.ProveAccount(accountID)
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errs.New(err))
+		return
+	}
+
+	resp := accountProofResponse{
+		LatestBlock: snapshot.Latest.Header.Number,
+		StateRoot:   snapshot.StateRoot,
+		Proof:       proof,
+	}
+	if acct, err := snapshot.Query(accountID); err == nil {
+		a := toAccount(acct)
+		resp.Account = &a
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// BlockByNumber returns the block with the specified number, which can also
+// be the keyword latest.
+func (h Handlers) BlockByNumber(ctx *gin.Context) {
+	num, err := h.blockNumber(ctx.Param("number"))
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	blockData, err := h.State.QueryBlock(num)
+	if err != nil {
+		h.blockError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, blockData)
+}
+
+// BlockByHash returns the block with the specified hash.
+func (h Handlers) BlockByHash(ctx *gin.Context) {
+	blockData, err := h.State.QueryBlockByHash(ctx.Param("hash"))
+	if err != nil {
+		h.blockError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, blockData)
+}
+
+// BlocksByRange returns the blocks in the specified inclusive range. Either
+// end can be the keyword latest, and the range can't be larger than
+// maxBlockRange.
+func (h Handlers) BlocksByRange(ctx *gin.Context) {
+	from, err := h.blockNumber(ctx.Param("from"))
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	to, err := h.blockNumber(ctx.Param("to"))
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	switch {
+	case from > to:
+		err = fmt.Errorf("invalid range, from %d is after to %d", from, to)
+	case to-from >= maxBlockRange:
+		err = fmt.Errorf("invalid range, no more than %d blocks can be requested", maxBlockRange)
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	blocks, err := h.State.QueryBlocks(from, to)
+	if err != nil {
+		h.blockError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, blocks)
+}
+
+// TxProof returns the merkle proof that the transaction is part of the
+// specified block, so it can be verified against the block's trans root.
+func (h Handlers) TxProof(ctx *gin.Context) {
+	num, err := h.blockNumber(ctx.Param("block"))
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, errs.New(err))
+		return
+	}
+
+	txProof, err := h.State.QueryTxProof(num, ctx.Param("txhash"))
+	if err != nil {
+		h.blockError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, toTxProofResponse(num, txProof))
+}
+
+// blockNumber converts the block number parameter, translating the keyword
+// latest into the number of the latest block.
+func (h Handlers) blockNumber(param string) (uint64, error) {
+	if param == "latest" {
+		return h.State.LatestBlock().Header.Number, nil
+	}
+
+	num, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block number %q", param)
+	}
+
+	return num, nil
+}
+
+// blockError responds with the error from a block query.
+func (h Handlers) blockError(ctx *gin.Context, err error) {
+	_ = ctx.Error(err)
+
+	if errors.Is(err, database.ErrBlockNotFound) || errors.Is(err, database.ErrTxNotFound) {
+		ctx.JSON(http.StatusNotFound, errs.New(err))
+		return
+	}
+
+	ctx.JSON(http.StatusInternalServerError, errs.New(err))
+}
@@ -4,33 +4,53 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sphierex/blockchain/cmd/apps/node/handlers/v1/private"
 	"github.com/sphierex/blockchain/cmd/apps/node/handlers/v1/public"
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
 	"go.uber.org/zap"
 )
 
 const version = "v1"
 
+// Config contains all the mandatory systems required by handlers.
 type Config struct {
-	Log *zap.SugaredLogger
+	Log   *zap.SugaredLogger
+	State *state.State
 }
 
 // PublicRoutes binds all the version 1 public routes.
 func PublicRoutes(app *gin.Engine, cfg Config) {
-	pbl := public.Handlers{Log: cfg.Log}
+	pbl := public.Handlers{
+		Log:   cfg.Log,
+		State: cfg.State,
+	}
 
 	v1 := app.Group(version)
 	{
 		v1.GET("/sample", pbl.Sample)
+		v1.POST("/tx/submit", pbl.SubmitWalletTransaction)
+		v1.GET("/tx/proof/:block/:txhash", pbl.TxProof)
+		v1.GET("/accounts/list", pbl.Accounts)
+		v1.GET("/accounts/list/:account", pbl.Account)
+		v1.GET("/accounts/proof/:account", pbl.AccountProof)
+		v1.GET("/blocks/:number", pbl.BlockByNumber)
+		v1.GET("/blocks/hash/:hash", pbl.BlockByHash)
+		v1.GET("/blocks/list/:from/:to", pbl.BlocksByRange)
 	}
 }
 
 // PrivateRoutes binds all the version 1 private routes.
 func PrivateRoutes(app *gin.Engine, cfg Config) {
 	prv := private.Handlers{
-		Log: cfg.Log,
+		Log:   cfg.Log,
+		State: cfg.State,
 	}
 
 	v1 := app.Group(version)
 	{
 		v1.GET("/node/sample", prv.Sample)
+		v1.GET("/node/status", prv.Status)
+		v1.POST("/node/peers", prv.SubmitPeer)
+		v1.POST("/node/block/propose", prv.ProposeBlock)
+		v1.GET("/node/block/list/:from/:to", prv.BlocksByRange)
+		v1.POST("/node/tx/submit", prv.SubmitNodeTransactions)
 	}
 }
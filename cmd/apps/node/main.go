@@ -12,7 +12,12 @@ import (
 
 	"github.com/ardanlabs/conf/v3"
 	"github.com/sphierex/blockchain/cmd/apps/node/handlers"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
 	"github.com/sphierex/blockchain/pkg/blockchain/genesis"
+	"github.com/sphierex/blockchain/pkg/blockchain/peer"
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
+	"github.com/sphierex/blockchain/pkg/blockchain/storage/disk"
+	"github.com/sphierex/blockchain/pkg/blockchain/worker"
 	"github.com/sphierex/blockchain/pkg/logger"
 	"go.uber.org/zap"
 )
@@ -52,6 +57,18 @@ func run(log *zap.SugaredLogger) error {
 			PublicHost      string        `conf:"default:0.0.0.0:8080"`
 			PrivateHost     string        `conf:"default:0.0.0.0:9080"`
 		}
+		State struct {
+			Beneficiary      string        `conf:"default:0xF01813E4B85e178A83e29B8E7bF26BD830a25f32"`
+			KnownPeers       []string      `conf:"default:0.0.0.0:9080;0.0.0.0:9180"`
+			DBPath           string        `conf:"default:zblock/blocks/"`
+			SelectStrategy   string        `conf:"default:tip"`
+			ReplaceBump      uint64        `conf:"default:10"`
+			MaxFuturePerAcct int           `conf:"default:16"`
+			MaxMempool       int           `conf:"default:10000"`
+			MaxPerAcct       int           `conf:"default:64"`
+			MempoolTTL       time.Duration `conf:"default:3h"`
+			MiningWorkers    int           `conf:"default:0"`
+		}
 	}{
 		Version: conf.Version{
 			Build: build,
@@ -92,6 +109,58 @@ func run(log *zap.SugaredLogger) error {
 	}
 	log.Infow("startup", "genesis", gen)
 
+	// Construct the storage the blockchain is written to and read from.
+	storage, err := disk.New(cfg.State.DBPath)
+	if err != nil {
+		return fmt.Errorf("storage open: %w", err)
+	}
+
+	beneficiaryID, err := database.ToAccountID(cfg.State.Beneficiary)
+	if err != nil {
+		return fmt.Errorf("beneficiary: %w", err)
+	}
+
+	// The peer set is the list of nodes this node starts out talking to.
+	peerSet := peer.NewPeerSet()
+	for _, host := range cfg.State.KnownPeers {
+		if _, err := peerSet.Add(peer.New(host)); err != nil {
+			return fmt.Errorf("known peer %s: %w", host, err)
+		}
+	}
+
+	// The state value represents the blockchain node and manages the
+	// blockchain database, replaying all the stored blocks on top of the
+	// genesis balances.
+	st, err := state.New(state.Config{
+		BeneficiaryID:    beneficiaryID,
+		Host:             cfg.Web.PrivateHost,
+		KnownPeers:       peerSet,
+		Genesis:          gen,
+		Storage:          storage,
+		SelectStrategy:   cfg.State.SelectStrategy,
+		ReplaceBump:      cfg.State.ReplaceBump,
+		MaxFuturePerAcct: cfg.State.MaxFuturePerAcct,
+		MaxMempool:       cfg.State.MaxMempool,
+		MaxPerAcct:       cfg.State.MaxPerAcct,
+		MempoolTTL:       cfg.State.MempoolTTL,
+		MiningWorkers:    cfg.State.MiningWorkers,
+		Log:              log,
+	})
+	if err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	defer func() {
+		if err := st.Shutdown(); err != nil {
+			log.Errorw("shutdown", "status", "state shutdown", "ERROR", err)
+		}
+	}()
+	log.Infow("startup", "status", "state ready", "latest_block", st.LatestBlock().Header.Number, "state_root", st.HashState())
+
+	// The worker package implements the different workflows such as mining,
+	// peer updates and the initial sync with the peers, and registers itself
+	// with the state so it's shut down with it.
+	worker.Run(st, log)
+
 	// =========================================================================
 	// Start Debug Service
 
@@ -101,7 +170,7 @@ func run(log *zap.SugaredLogger) error {
 	// related endpoints. This includes the standard library endpoints.
 
 	// Construct the mux for the debug calls.
-	debugMux := handlers.DebugMux(build, log)
+	debugMux := handlers.DebugMux(build, log, st)
 
 	// Start the service listening for debug requests.
 	// Not concerned with shutting this down with load shedding.
@@ -132,6 +201,7 @@ func run(log *zap.SugaredLogger) error {
 	publicMux := handlers.PublicMux(handlers.MuxConfig{
 		Shutdown: shutdown,
 		Log:      log,
+		State:    st,
 	})
 
 	// Construct a server to service the requests against the mux.
@@ -159,6 +229,7 @@ func run(log *zap.SugaredLogger) error {
 	privateMux := handlers.PrivateMux(handlers.MuxConfig{
 		Shutdown: shutdown,
 		Log:      log,
+		State:    st,
 	})
 
 	// Construct a server to service the requests against the mux.
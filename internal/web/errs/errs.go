@@ -0,0 +1,15 @@
+// Package errs provides the error body returned by the web api.
+package errs
+
+// Response is the form used for API responses from failures in the API.
+type Response struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// New constructs a response for the specified error.
+func New(err error) Response {
+	return Response{
+		Error: err.Error(),
+	}
+}
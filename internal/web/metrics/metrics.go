@@ -5,6 +5,7 @@ import (
 	"context"
 	"expvar"
 	"runtime"
+	"strconv"
 )
 
 // This holds the single instance of the metrics value needed for
@@ -22,6 +23,8 @@ type metrics struct {
 	requests   *expvar.Int
 	errors     *expvar.Int
 	panics     *expvar.Int
+	hashRate   *expvar.Map
+	evictions  *expvar.Map
 }
 
 // init constructs the metrics value that will be used to capture metrics.
@@ -34,6 +37,8 @@ func init() {
 		requests:   expvar.NewInt("requests"),
 		errors:     expvar.NewInt("errors"),
 		panics:     expvar.NewInt("panics"),
+		hashRate:   expvar.NewMap("mining_hash_rate"),
+		evictions:  expvar.NewMap("mempool_evictions"),
 	}
 }
 
@@ -87,3 +92,18 @@ func AddPanics(ctx context.Context) {
 		v.panics.Add(1)
 	}
 }
+
+// SetMiningHashRate records the hashes per second calculated by the specified
+// mining worker. Mining doesn't happen inside a request so this doesn't use
+// the context.
+func SetMiningHashRate(worker int, hashRate float64) {
+	v := new(expvar.Float)
+	v.Set(hashRate)
+	m.hashRate.Set(strconv.Itoa(worker), v)
+}
+
+// AddMempoolEvictions increments the number of transactions evicted from the
+// mempool for the specified reason.
+func AddMempoolEvictions(reason string, n int) {
+	m.evictions.Add(reason, int64(n))
+}
@@ -1,6 +1,12 @@
 package database
 
-import "errors"
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+)
 
 // Account represents information stored in the database for an individual account.
 type Account struct {
@@ -9,6 +15,25 @@ type Account struct {
 	Balance   uint64
 }
 
+// newAccount constructs a new account value for use.
+func newAccount(accountID AccountID, balance uint64) Account {
+	return Account{
+		AccountID: accountID,
+		Balance:   balance,
+	}
+}
+
+// Hash returns the hash of the account that is stored in the state tree,
+// which is the sha256 of the nonce and balance as 8 byte big endian values.
+func (a Account) Hash() []byte {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:8], a.Nonce)
+	binary.BigEndian.PutUint64(data[8:], a.Balance)
+
+	h := sha256.Sum256(data[:])
+	return h[:]
+}
+
 // ============================================================================
 
 // AccountID represents an account id that is used to sign transactions and is
@@ -37,6 +62,18 @@ func (a AccountID) IsAccountID() bool {
 	return len(a) == 2*addressLength && isHex(a)
 }
 
+// Bytes returns the 20 bytes of the address, which is the key of the account
+// in the state tree.
+func (a AccountID) Bytes() []byte {
+	return common.HexToAddress(string(a)).Bytes()
+}
+
+// normalize returns the account in its checksummed form so the same address
+// always maps to the same account regardless of the case it was written in.
+func (a AccountID) normalize() AccountID {
+	return AccountID(common.HexToAddress(string(a)).Hex())
+}
+
 // has0xPrefix validates the account starts with a 0x.
 func has0xPrefix(a AccountID) bool {
 	return len(a) >= 2 && a[0] == '0' && (a[1] == 'x' || a[1] == 'X')
@@ -1,6 +1,17 @@
 package database
 
-import "github.com/sphierex/blockchain/pkg/blockchain/merkle"
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sphierex/blockchain/pkg/blockchain/genesis"
+	"github.com/sphierex/blockchain/pkg/blockchain/merkle"
+	"github.com/sphierex/blockchain/pkg/blockchain/signature"
+)
 
 // =============================================================================
 
@@ -11,6 +22,47 @@ type BlockData struct {
 	Trans  []BlockTx   `json:"trans"`
 }
 
+// ToBlockData converts a block into a value that can be serialized.
+func ToBlockData(block Block) BlockData {
+	var trans []BlockTx
+	if block.MerkleTree != nil {
+		trans = block.MerkleTree.Values()
+	}
+
+	return BlockData{
+		Hash:   block.Hash(),
+		Header: block.Header,
+		Trans:  trans,
+	}
+}
+
+// ToBlock converts the serialized block data back into a block, rebuilding
+// the merkle tree of transactions concurrently for large blocks. The rebuilt
+// tree must produce the transaction root recorded in the header.
+func ToBlock(blockData BlockData) (Block, error) {
+	block := Block{
+		Header: blockData.Header,
+	}
+
+	if len(blockData.Trans) > 0 {
+		tree, err := merkle.NewTree(blockData.Trans, merkle.WithConcurrency[BlockTx](runtime.NumCPU()))
+		if err != nil {
+			return Block{}, err
+		}
+		block.MerkleTree = tree
+	}
+
+	if transRoot := block.transRoot(); transRoot != blockData.Header.TransRoot {
+		return Block{}, fmt.Errorf("trans root mismatch, got %s, exp %s", transRoot, blockData.Header.TransRoot)
+	}
+
+	if blockData.Hash != "" && blockData.Hash != block.Hash() {
+		return Block{}, fmt.Errorf("block hash mismatch, got %s, exp %s", block.Hash(), blockData.Hash)
+	}
+
+	return block, nil
+}
+
 // =============================================================================
 
 // BlockHeader represents common information required for each block.
@@ -31,3 +83,168 @@ type Block struct {
 	Header     BlockHeader
 	MerkleTree *merkle.Tree[BlockTx]
 }
+
+// Hash returns the unique hash for the block. The genesis block, which is
+// never stored, hashes to the zero hash.
+func (b Block) Hash() string {
+	if b.Header.Number == 0 {
+		return signature.ZeroHash
+	}
+
+	return signature.Hash(b.Header)
+}
+
+// transRoot returns the merkle root of the transactions in the block, or the
+// zero hash for a block without transactions.
+func (b Block) transRoot() string {
+	if b.MerkleTree == nil {
+		return signature.ZeroHash
+	}
+
+	return b.MerkleTree.RootHex()
+}
+
+// ErrTxNotFound is returned when a transaction is not part of a block.
+var ErrTxNotFound = errors.New("transaction not found")
+
+// TxProof is the merkle inclusion proof of a transaction in a block. Hashing
+// the leaf hash with each proof hash in order produces the transaction root.
+type TxProof struct {
+	Tx        BlockTx
+	LeafHash  []byte
+	Proof     [][]byte
+	Order     []int64
+	TransRoot string
+}
+
+// ProveTx finds the transaction with the specified hash in the block and
+// returns the proof of its inclusion under the block's transaction root.
+func (b Block) ProveTx(txHash string) (TxProof, error) {
+	if b.MerkleTree == nil {
+		return TxProof{}, ErrTxNotFound
+	}
+
+	for _, tx := range b.MerkleTree.Values() {
+		leafHash, err := tx.Hash()
+		if err != nil {
+			return TxProof{}, err
+		}
+
+		if !strings.EqualFold(hexutil.Encode(leafHash), txHash) {
+			continue
+		}
+
+		proof, order, err := b.MerkleTree.Proof(tx)
+		if err != nil {
+			return TxProof{}, err
+		}
+
+		txProof := TxProof{
+			Tx:        tx,
+			LeafHash:  leafHash,
+			Proof:     proof,
+			Order:     order,
+			TransRoot: b.Header.TransRoot,
+		}
+
+		return txProof, nil
+	}
+
+	return TxProof{}, ErrTxNotFound
+}
+
+// =============================================================================
+
+// maxTimeDrift is how far ahead of the local clock a block timestamp can be,
+// allowing for clocks between nodes not agreeing exactly.
+const maxTimeDrift = 30 * time.Second
+
+// validateBlock takes a block and validates it to be included into the
+// blockchain on top of the previous block with the specified difficulty.
+func validateBlock(block Block, previousBlock Block, gen genesis.Genesis, difficulty uint16) error {
+	// A block further ahead means blocks are missing, and a block at the
+	// next number for another previous block means the chain has forked.
+	// Both are recovered from by syncing with the peer that has the block.
+	nextNumber := previousBlock.Header.Number + 1
+	switch {
+	case block.Header.Number > nextNumber:
+		return fmt.Errorf("%w, got %d, exp %d", ErrBlockAhead, block.Header.Number, nextNumber)
+	case block.Header.Number != nextNumber:
+		return fmt.Errorf("this block is not the next number, got %d, exp %d", block.Header.Number, nextNumber)
+	}
+
+	if block.Header.PrevBlockHash != previousBlock.Hash() {
+		return fmt.Errorf("%w, prev block doesn't match our latest, got %s, exp %s", ErrChainForked, block.Header.PrevBlockHash, previousBlock.Hash())
+	}
+
+	if block.Header.TimeStamp < previousBlock.Header.TimeStamp {
+		return fmt.Errorf("block timestamp is before the prev block, got %d, prev %d", block.Header.TimeStamp, previousBlock.Header.TimeStamp)
+	}
+
+	// The difficulty is retargeted from the block timestamps, so a block
+	// can't claim to be from much later than now.
+	if maxTimeStamp := uint64(time.Now().Add(maxTimeDrift).Unix()); block.Header.TimeStamp > maxTimeStamp {
+		return fmt.Errorf("block timestamp is too far in the future, got %d, max %d", block.Header.TimeStamp, maxTimeStamp)
+	}
+
+	if block.Header.Difficulty != difficulty {
+		return fmt.Errorf("wrong block difficulty, got %d, exp %d", block.Header.Difficulty, difficulty)
+	}
+
+	hash := block.Hash()
+	if !isHashSolved(block.Header.Difficulty, hash) {
+		return fmt.Errorf("%s invalid block hash for difficulty %d", hash, block.Header.Difficulty)
+	}
+
+	if block.Header.MiningReward != gen.MiningReward {
+		return fmt.Errorf("block mining reward doesn't match genesis, got %d, exp %d", block.Header.MiningReward, gen.MiningReward)
+	}
+
+	if !block.Header.BeneficiaryID.IsAccountID() {
+		return errors.New("invalid beneficiary account")
+	}
+
+	if transRoot := block.transRoot(); block.Header.TransRoot != transRoot {
+		return fmt.Errorf("merkle root does not match transactions, got %s, exp %s", transRoot, block.Header.TransRoot)
+	}
+
+	if block.MerkleTree != nil {
+		trans := block.MerkleTree.Values()
+		if len(trans) > int(gen.TransPerBlock) {
+			return fmt.Errorf("too many transactions, got %d, max %d", len(trans), gen.TransPerBlock)
+		}
+
+		for _, tx := range trans {
+			if err := validateBlockTx(tx, gen); err != nil {
+				return fmt.Errorf("tx %s: %w", tx, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateBlockTx checks the transaction is signed for this chain and pays
+// the gas price from the genesis for one unit of gas. The gas fields aren't
+// covered by the signature, so they can't be trusted as they are.
+func validateBlockTx(tx BlockTx, gen genesis.Genesis) error {
+	if tx.ChainID != gen.ChainID {
+		return fmt.Errorf("wrong chain id, got %d, exp %d", tx.ChainID, gen.ChainID)
+	}
+
+	if tx.GasPrice != gen.GasPrice || tx.GasUnits != OneUnitOfGas {
+		return fmt.Errorf("wrong gas, got %d x %d, exp %d x %d", tx.GasPrice, tx.GasUnits, gen.GasPrice, OneUnitOfGas)
+	}
+
+	return tx.Validate()
+}
+
+// isHashSolved checks the hash to make sure it complies with the POW rules.
+// We need to match a difficulty number of 0's after the 0x prefix.
+func isHashSolved(difficulty uint16, hash string) bool {
+	if len(hash) != len(signature.ZeroHash) {
+		return false
+	}
+
+	return strings.HasPrefix(hash, "0x"+strings.Repeat("0", int(difficulty)))
+}
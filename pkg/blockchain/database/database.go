@@ -1,15 +1,538 @@
 package database
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/sphierex/blockchain/pkg/blockchain/genesis"
+	"github.com/sphierex/blockchain/pkg/blockchain/merkle"
+)
+
+// Set of errors returned when a block can't be added to the blockchain.
+var (
+	ErrBlockNotFound = errors.New("block not found")
+	ErrBlockAhead    = errors.New("block is ahead of the latest block")
+	ErrChainForked   = errors.New("block doesn't build on the latest block")
+	ErrNotEnoughWork = errors.New("chain doesn't have more work than the latest chain")
 )
 
 // Database manages data related to accounts who have transacted on the blockchain.
 type Database struct {
-	mu       sync.RWMutex
-	genesis  genesis.Genesis
-	latest   Block
-	accounts map[AccountID]Account
+	mu        sync.RWMutex
+	genesis   genesis.Genesis
+	latest    Block
+	accounts  map[AccountID]Account
+	stateRoot string
+	hashes    map[string]uint64
+	storage   Storage
+}
+
+// New constructs a new database and applies the account balance information
+// found in the genesis file. Then every block found in storage is replayed on
+// top of genesis to rebuild the accounts.
+func New(gen genesis.Genesis, storage Storage) (*Database, error) {
+	accounts, err := genesisAccounts(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	db := Database{
+		genesis:   gen,
+		accounts:  accounts,
+		stateRoot: hashAccounts(accounts),
+		hashes:    make(map[string]uint64),
+		storage:   storage,
+	}
+
+	// Read all the blocks from storage.
+	iter := storage.ForEach()
+	for blockData, err := iter.Next(); !iter.Done(); blockData, err = iter.Next() {
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := ToBlock(blockData)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", blockData.Header.Number, err)
+		}
+
+		// Validate the block values and cryptographic audit trail.
+		if err := db.validateBlock(block); err != nil {
+			return nil, fmt.Errorf("block %d: %w", block.Header.Number, err)
+		}
+
+		accounts, err := db.applyBlock(block)
+		if err != nil {
+			return nil, err
+		}
+
+		db.accounts = accounts
+		db.stateRoot = block.Header.StateRoot
+		db.latest = block
+		db.hashes[hashKey(block.Hash())] = block.Header.Number
+	}
+
+	return &db, nil
+}
+
+// Close closes the underlying storage.
+func (db *Database) Close() error {
+	return db.storage.Close()
+}
+
+// GetBlock returns the specified block from storage. ErrBlockNotFound is
+// returned for a number that hasn't been applied to the database.
+func (db *Database) GetBlock(num uint64) (BlockData, error) {
+	latest := db.LatestBlock()
+	if num == 0 || num > latest.Header.Number {
+		return BlockData{}, ErrBlockNotFound
+	}
+
+	return db.storage.GetBlock(num)
+}
+
+// GetBlockByHash returns the block with the specified hash from storage,
+// looking up its number in the index of block hashes. ErrBlockNotFound is
+// returned when no block has the hash.
+func (db *Database) GetBlockByHash(hash string) (BlockData, error) {
+	db.mu.RLock()
+	num, exists := db.hashes[hashKey(hash)]
+	db.mu.RUnlock()
+
+	if !exists {
+		return BlockData{}, ErrBlockNotFound
+	}
+
+	return db.GetBlock(num)
+}
+
+// GetBlocks returns the blocks from storage in the specified range, which is
+// inclusive on both ends. The range is cut short at the latest block.
+func (db *Database) GetBlocks(from uint64, to uint64) ([]BlockData, error) {
+	if from == 0 {
+		from = 1
+	}
+
+	latest := db.LatestBlock()
+	if to > latest.Header.Number {
+		to = latest.Header.Number
+	}
+
+	blocks := []BlockData{}
+	for num := from; num <= to; num++ {
+		blockData, err := db.storage.GetBlock(num)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", num, err)
+		}
+		blocks = append(blocks, blockData)
+	}
+
+	return blocks, nil
+}
+
+// ForEach returns an iterator to walk through all the blocks in storage
+// starting with block number 1.
+func (db *Database) ForEach() Iterator {
+	return db.storage.ForEach()
+}
+
+// Genesis returns the genesis information the database was constructed with.
+func (db *Database) Genesis() genesis.Genesis {
+	return db.genesis
+}
+
+// LatestBlock returns the latest block applied to the database.
+func (db *Database) LatestBlock() Block {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.latest
+}
+
+// Query retrieves an account from the database.
+func (db *Database) Query(accountID AccountID) (Account, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	account, exists := db.accounts[accountID.normalize()]
+	if !exists {
+		return Account{}, errors.New("account does not exist")
+	}
+
+	return account, nil
+}
+
+// Copy makes a copy of the current accounts in the database.
+func (db *Database) Copy() map[AccountID]Account {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	accounts := make(map[AccountID]Account, len(db.accounts))
+	for accountID, account := range db.accounts {
+		accounts[accountID] = account
+	}
+
+	return accounts
+}
+
+// Snapshot is a consistent view of the accounts as of the latest block.
+type Snapshot struct {
+	Latest    Block
+	StateRoot string
+	Accounts  map[AccountID]Account
+}
+
+// Snapshot makes a copy of the current accounts along with the latest block
+// and state root they belong to.
+func (db *Database) Snapshot() Snapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	accounts := make(map[AccountID]Account, len(db.accounts))
+	for accountID, account := range db.accounts {
+		accounts[accountID] = account
+	}
+
+	snapshot := Snapshot{
+		Latest:    db.latest,
+		StateRoot: db.stateRoot,
+		Accounts:  accounts,
+	}
+
+	return snapshot
+}
+
+// Prove returns the proof that the account is or is not part of the state
+// root of the snapshot.
+func (s Snapshot) Prove(accountID AccountID) (merkle.SparseProof, error) {
+	return accountsTree(s.Accounts).Proof(accountID.Bytes())
+}
+
+// Query retrieves an account from the snapshot.
+func (s Snapshot) Query(accountID AccountID) (Account, error) {
+	account, exists := s.Accounts[accountID.normalize()]
+	if !exists {
+		return Account{}, errors.New("account does not exist")
+	}
+
+	return account, nil
+}
+
+// ApplyMiningReward gives the beneficiary of the block the mining reward
+// defined in the genesis file. The balance is left alone if the reward
+// doesn't fit.
+func (db *Database) ApplyMiningReward(block Block) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := applyMiningReward(db.accounts, block.Header.BeneficiaryID, db.genesis.MiningReward); err != nil {
+		return err
+	}
+	db.stateRoot = hashAccounts(db.accounts)
+
+	return nil
+}
+
+// ApplyTransaction performs the business logic for applying a transaction
+// to the database. The transaction is rejected and no balances are changed
+// if the nonce is out of sequence or the sender can't pay for it.
+func (db *Database) ApplyTransaction(block Block, tx BlockTx) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := applyTransaction(db.accounts, block.Header.BeneficiaryID, tx); err != nil {
+		return err
+	}
+	db.stateRoot = hashAccounts(db.accounts)
+
+	return nil
+}
+
+// ValidateBlock checks the block can be added on top of the latest block:
+// the number and previous hash follow on, the hash solves the difficulty,
+// the transaction root matches and every transaction is properly signed
+// for this chain and pays the genesis gas price for one unit of gas.
+func (db *Database) ValidateBlock(block Block) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.validateBlock(block)
+}
+
+// ApplyBlock validates the block against the latest block and applies all
+// the transactions in the block and the mining reward to a copy of the
+// accounts, then compares the resulting state root with the one claimed by
+// the block header. The database is only changed if the roots match and the
+// block is written to storage, and the block becomes the latest block.
+func (db *Database) ApplyBlock(block Block) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.validateBlock(block); err != nil {
+		return fmt.Errorf("block %d: %w", block.Header.Number, err)
+	}
+
+	accounts, err := db.applyBlock(block)
+	if err != nil {
+		return err
+	}
+
+	if err := db.storage.Write(ToBlockData(block)); err != nil {
+		return fmt.Errorf("block %d, write: %w", block.Header.Number, err)
+	}
+
+	db.accounts = accounts
+	db.stateRoot = block.Header.StateRoot
+	db.latest = block
+	db.hashes[hashKey(block.Hash())] = block.Header.Number
+
+	return nil
+}
+
+// NewCandidate constructs the header for the next block to be mined by the
+// beneficiary. The transactions are applied in order against a copy of the
+// accounts, and any that can't be applied are left out, so the returned
+// transactions and the state root in the header always belong together.
+func (db *Database) NewCandidate(beneficiaryID AccountID, trans []BlockTx) (BlockHeader, []BlockTx, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	difficulty, err := db.nextDifficulty()
+	if err != nil {
+		return BlockHeader{}, nil, err
+	}
+
+	accounts := make(map[AccountID]Account, len(db.accounts))
+	for accountID, account := range db.accounts {
+		accounts[accountID] = account
+	}
+
+	accepted := make([]BlockTx, 0, len(trans))
+	for _, tx := range trans {
+		if validateBlockTx(tx, db.genesis) != nil {
+			continue
+		}
+
+		if err := applyTransaction(accounts, beneficiaryID, tx); err != nil {
+			continue
+		}
+
+		accepted = append(accepted, tx)
+	}
+
+	if err := applyMiningReward(accounts, beneficiaryID, db.genesis.MiningReward); err != nil {
+		return BlockHeader{}, nil, err
+	}
+
+	// The timestamp can't go backwards even if the clocks between nodes
+	// don't agree.
+	timeStamp := uint64(time.Now().UTC().Unix())
+	if timeStamp < db.latest.Header.TimeStamp {
+		timeStamp = db.latest.Header.TimeStamp
+	}
+
+	header := BlockHeader{
+		Number:        db.latest.Header.Number + 1,
+		PrevBlockHash: db.latest.Hash(),
+		TimeStamp:     timeStamp,
+		BeneficiaryID: beneficiaryID,
+		Difficulty:    difficulty,
+		MiningReward:  db.genesis.MiningReward,
+		StateRoot:     hashAccounts(accounts),
+	}
+
+	return header, accepted, nil
+}
+
+// HashState returns the root of the sparse merkle tree of the accounts and
+// their balances. Two databases holding the same accounts always produce the
+// same hash, which is recorded in each block as the state root. The root is
+// kept up to date as the accounts change, so this doesn't rebuild the tree.
+func (db *Database) HashState() string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.stateRoot
+}
+
+// validateBlock validates the block against the latest block and the
+// difficulty expected for the next block. The caller must hold the lock.
+func (db *Database) validateBlock(block Block) error {
+	difficulty, err := db.nextDifficulty()
+	if err != nil {
+		return err
+	}
+
+	return validateBlock(block, db.latest, db.genesis, difficulty)
+}
+
+// applyBlock returns a copy of the accounts with the block applied after
+// checking the resulting state root matches the block header. The caller
+// must hold the lock.
+func (db *Database) applyBlock(block Block) (map[AccountID]Account, error) {
+	accounts := make(map[AccountID]Account, len(db.accounts))
+	for accountID, account := range db.accounts {
+		accounts[accountID] = account
+	}
+
+	if block.MerkleTree != nil {
+		for _, tx := range block.MerkleTree.Values() {
+			if err := applyTransaction(accounts, block.Header.BeneficiaryID, tx); err != nil {
+				return nil, fmt.Errorf("block %d, tx %s: %w", block.Header.Number, tx, err)
+			}
+		}
+	}
+
+	if err := applyMiningReward(accounts, block.Header.BeneficiaryID, db.genesis.MiningReward); err != nil {
+		return nil, fmt.Errorf("block %d: %w", block.Header.Number, err)
+	}
+
+	if stateRoot := hashAccounts(accounts); stateRoot != block.Header.StateRoot {
+		return nil, fmt.Errorf("block %d, state root mismatch, got %s, exp %s", block.Header.Number, stateRoot, block.Header.StateRoot)
+	}
+
+	return accounts, nil
+}
+
+// =============================================================================
+
+// genesisAccounts constructs the set of accounts defined in the genesis file.
+func genesisAccounts(gen genesis.Genesis) (map[AccountID]Account, error) {
+	accounts := make(map[AccountID]Account, len(gen.Balances))
+	for accountStr, balance := range gen.Balances {
+		accountID, err := ToAccountID(accountStr)
+		if err != nil {
+			return nil, fmt.Errorf("genesis account %q: %w", accountStr, err)
+		}
+
+		accountID = accountID.normalize()
+		accounts[accountID] = newAccount(accountID, balance)
+	}
+
+	return accounts, nil
+}
+
+// accountsTree constructs the sparse merkle tree of the specified accounts,
+// keyed by the bytes of the account id so the case of the address doesn't
+// matter.
+func accountsTree(accounts map[AccountID]Account) *merkle.Sparse {
+	tree := merkle.NewSparse(common.AddressLength)
+	for accountID, account := range accounts {
+		_ = tree.Set(accountID.Bytes(), account.Hash())
+	}
+
+	return tree
+}
+
+// hashAccounts produces the state root of the specified accounts, which is
+// the root of their sparse merkle tree.
+func hashAccounts(accounts map[AccountID]Account) string {
+	return accountsTree(accounts).RootHex()
+}
+
+// hashKey normalizes a block hash for the index of block hashes, so the
+// lookup doesn't depend on the case of the hash.
+func hashKey(hash string) string {
+	return strings.ToLower(hash)
+}
+
+// applyMiningReward credits the beneficiary with the reward inside the
+// specified set of accounts.
+func applyMiningReward(accounts map[AccountID]Account, beneficiaryID AccountID, reward uint64) error {
+	beneficiaryID = beneficiaryID.normalize()
+
+	bnfc, exists := accounts[beneficiaryID]
+	if !exists {
+		bnfc = newAccount(beneficiaryID, 0)
+	}
+
+	balance, err := AddAmounts(bnfc.Balance, reward)
+	if err != nil {
+		return fmt.Errorf("mining reward, beneficiary balance: %w", err)
+	}
+
+	bnfc.Balance = balance
+	accounts[beneficiaryID] = bnfc
+
+	return nil
+}
+
+// applyTransaction moves the value, tip and gas fee of the transaction between
+// the parties inside the specified set of accounts.
+func applyTransaction(accounts map[AccountID]Account, beneficiaryID AccountID, tx BlockTx) error {
+	fromID, err := tx.FromAccount()
+	if err != nil {
+		return fmt.Errorf("invalid signature, %w", err)
+	}
+
+	fromID = fromID.normalize()
+	toID := tx.ToID.normalize()
+	beneficiaryID = beneficiaryID.normalize()
+
+	from, exists := accounts[fromID]
+	if !exists {
+		from = newAccount(fromID, 0)
+	}
+
+	// Perform basic accounting checks.
+	if tx.Nonce != from.Nonce+1 {
+		return fmt.Errorf("transaction invalid, wrong nonce, got %d, exp %d", tx.Nonce, from.Nonce+1)
+	}
+
+	gasFee, err := tx.GasFee()
+	if err != nil {
+		return fmt.Errorf("transaction invalid, %w", err)
+	}
+
+	cost, err := tx.Cost()
+	if err != nil {
+		return fmt.Errorf("transaction invalid, %w", err)
+	}
+
+	if from.Balance < cost {
+		return fmt.Errorf("transaction invalid, insufficient funds, bal %d, needed %d", from.Balance, cost)
+	}
+
+	// The changes are staged so the accounts are left alone when a credit
+	// overflows. Reads go through the staged accounts in case someone sends
+	// value to themselves or the beneficiary.
+	staged := make(map[AccountID]Account, 3)
+	account := func(accountID AccountID) Account {
+		if account, exists := staged[accountID]; exists {
+			return account
+		}
+		if account, exists := accounts[accountID]; exists {
+			return account
+		}
+		return newAccount(accountID, 0)
+	}
+
+	// Debit the sender and update the nonce for the next transaction check.
+	from.Balance -= cost
+	from.Nonce = tx.Nonce
+	staged[fromID] = from
+
+	// Credit the receiver with the value.
+	to := account(toID)
+	if to.Balance, err = AddAmounts(to.Balance, tx.Value); err != nil {
+		return fmt.Errorf("transaction invalid, receiver balance: %w", err)
+	}
+	staged[toID] = to
+
+	// Give the beneficiary the tip and the gas fee.
+	bnfc := account(beneficiaryID)
+	if bnfc.Balance, err = AddAmounts(bnfc.Balance, tx.Tip, gasFee); err != nil {
+		return fmt.Errorf("transaction invalid, beneficiary balance: %w", err)
+	}
+	staged[beneficiaryID] = bnfc
+
+	for accountID, account := range staged {
+		accounts[accountID] = account
+	}
+
+	return nil
 }
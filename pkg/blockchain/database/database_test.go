@@ -0,0 +1,695 @@
+package database_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/genesis"
+	"github.com/sphierex/blockchain/pkg/blockchain/merkle"
+	"github.com/sphierex/blockchain/pkg/blockchain/signature"
+	"github.com/sphierex/blockchain/pkg/blockchain/storage/memory"
+)
+
+func Test_ApplyTransaction(t *testing.T) {
+	from := newKey(t)
+	to := newKey(t)
+	bnfc := newKey(t)
+
+	db, err := database.New(genesisFor(map[*ecdsa.PrivateKey]uint64{from: 1000}), memory.New())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	block := database.Block{Header: database.BlockHeader{BeneficiaryID: accountID(bnfc)}}
+
+	table := []struct {
+		testCaseID int
+		nonce      uint64
+		value      uint64
+		tip        uint64
+		success    bool
+	}{
+		{testCaseID: 1, nonce: 1, value: 100, tip: 10, success: true},
+		{testCaseID: 2, nonce: 1, value: 100, tip: 10, success: false},
+		{testCaseID: 3, nonce: 3, value: 100, tip: 10, success: false},
+		{testCaseID: 4, nonce: 2, value: 5000, tip: 10, success: false},
+		{testCaseID: 5, nonce: 2, value: 200, tip: 0, success: true},
+		{testCaseID: 6, nonce: 3, value: math.MaxUint64, tip: 1, success: false},
+		{testCaseID: 7, nonce: 3, value: math.MaxUint64 - 1, tip: 0, success: false},
+	}
+
+	for _, tt := range table {
+		tx := database.NewBlockTx(signTx(t, from, tt.nonce, accountID(to), tt.value, tt.tip), 1, 1)
+		err := db.ApplyTransaction(block, tx)
+		if tt.success && err != nil {
+			t.Errorf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+		if !tt.success && err == nil {
+			t.Errorf("[case:%d] error: expected transaction to be rejected", tt.testCaseID)
+		}
+	}
+
+	if err := db.ApplyMiningReward(block); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	balances := []struct {
+		account database.AccountID
+		nonce   uint64
+		balance uint64
+	}{
+		{account: accountID(from), nonce: 2, balance: 1000 - 110 - 1 - 200 - 1},
+		{account: accountID(to), nonce: 0, balance: 300},
+		{account: accountID(bnfc), nonce: 0, balance: 10 + 1 + 1 + 700},
+	}
+
+	for i, b := range balances {
+		account, err := db.Query(b.account)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", i, err)
+		}
+		if account.Balance != b.balance {
+			t.Errorf("[case:%d] error: expected balance %d, got %d", i, b.balance, account.Balance)
+		}
+		if account.Nonce != b.nonce {
+			t.Errorf("[case:%d] error: expected nonce %d, got %d", i, b.nonce, account.Nonce)
+		}
+	}
+}
+
+func Test_ApplyBlock(t *testing.T) {
+	from := newKey(t)
+	to := newKey(t)
+	bnfc := newKey(t)
+
+	gen := genesisFor(map[*ecdsa.PrivateKey]uint64{from: 1000})
+
+	storage := memory.New()
+
+	db1, err := database.New(gen, storage)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	db2, err := database.New(gen, memory.New())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if db1.HashState() != db2.HashState() {
+		t.Fatalf("error: expected the same state root for the same genesis")
+	}
+
+	trans := []database.BlockTx{
+		database.NewBlockTx(signTx(t, from, 1, accountID(to), 100, 10), 1, 1),
+		database.NewBlockTx(signTx(t, from, 2, accountID(to), 100, 10), 1, 1),
+	}
+	tree, err := merkle.NewTree(trans)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	block := database.Block{
+		Header: database.BlockHeader{
+			Number:        1,
+			PrevBlockHash: signature.ZeroHash,
+			BeneficiaryID: accountID(bnfc),
+			Difficulty:    gen.Difficulty,
+			MiningReward:  gen.MiningReward,
+			StateRoot:     "0xbad",
+			TransRoot:     tree.RootHex(),
+		},
+		MerkleTree: tree,
+	}
+	solve(&block)
+
+	stateRoot := db1.HashState()
+	if err := db1.ApplyBlock(block); err == nil {
+		t.Fatalf("error: expected block with the wrong state root to be rejected")
+	}
+	if db1.HashState() != stateRoot {
+		t.Fatalf("error: expected a rejected block to leave the state unchanged")
+	}
+
+	for _, tx := range trans {
+		if err := db2.ApplyTransaction(block, tx); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+	if err := db2.ApplyMiningReward(block); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	block.Header.StateRoot = db2.HashState()
+	solve(&block)
+	if err := db1.ApplyBlock(block); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if db1.LatestBlock().Header.Number != 1 {
+		t.Errorf("error: expected latest block to be 1, got %d", db1.LatestBlock().Header.Number)
+	}
+
+	// The snapshot carries the state root of the block it was taken at.
+	if snapshot := db1.Snapshot(); snapshot.StateRoot != block.Header.StateRoot || db1.HashState() != block.Header.StateRoot {
+		t.Errorf("error: expected state root %s, got %s and %s", block.Header.StateRoot, snapshot.StateRoot, db1.HashState())
+	}
+
+	// A new database over the same storage must replay to the same state.
+	db3, err := database.New(gen, storage)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if db3.HashState() != db1.HashState() {
+		t.Errorf("error: expected replayed state root %s, got %s", db1.HashState(), db3.HashState())
+	}
+	if db3.LatestBlock().Hash() != db1.LatestBlock().Hash() {
+		t.Errorf("error: expected replayed latest block %s, got %s", db1.LatestBlock().Hash(), db3.LatestBlock().Hash())
+	}
+}
+
+func Test_ApplyMiningReward(t *testing.T) {
+	bnfc := newKey(t)
+
+	db, err := database.New(genesisFor(map[*ecdsa.PrivateKey]uint64{bnfc: math.MaxUint64 - 100}), memory.New())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	// A reward that doesn't fit in the balance is rejected.
+	block := database.Block{Header: database.BlockHeader{BeneficiaryID: accountID(bnfc)}}
+	if err := db.ApplyMiningReward(block); !errors.Is(err, database.ErrOverflow) {
+		t.Errorf("error: expected ErrOverflow, got %v", err)
+	}
+
+	account, err := db.Query(accountID(bnfc))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if account.Balance != math.MaxUint64-100 {
+		t.Errorf("error: expected the balance to be unchanged, got %d", account.Balance)
+	}
+
+	// No block can be mined that would pay the reward.
+	if _, _, err := db.NewCandidate(accountID(bnfc), nil); !errors.Is(err, database.ErrOverflow) {
+		t.Errorf("error: expected ErrOverflow, got %v", err)
+	}
+}
+
+func Test_ValidateBlock(t *testing.T) {
+	from := newKey(t)
+	to := newKey(t)
+	bnfc := newKey(t)
+
+	gen := genesisFor(map[*ecdsa.PrivateKey]uint64{from: 1000})
+
+	db, err := database.New(gen, memory.New())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	good := database.NewBlockTx(signTx(t, from, 1, accountID(to), 100, 10), 1, 1)
+
+	wrongChain, err := database.NewTx(2, 1, accountID(to), 100, 10, nil)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	wrongChainTx, err := wrongChain.Sign(from)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	table := []struct {
+		testCaseID int
+		trans      []database.BlockTx
+		change     func(h *database.BlockHeader)
+		success    bool
+		kind       error
+	}{
+		{testCaseID: 1, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) {}, success: true},
+		{testCaseID: 2, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) { h.Number = 2 }, success: false, kind: database.ErrBlockAhead},
+		{testCaseID: 3, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) { h.PrevBlockHash = "0x01" }, success: false, kind: database.ErrChainForked},
+		{testCaseID: 4, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) { h.TransRoot = signature.ZeroHash }, success: false},
+		{testCaseID: 5, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) { h.Difficulty = 0 }, success: false},
+		{testCaseID: 6, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) { h.MiningReward = 1 }, success: false},
+		{testCaseID: 7, trans: []database.BlockTx{database.NewBlockTx(wrongChainTx, 1, 1)}, change: func(h *database.BlockHeader) {}, success: false},
+		{testCaseID: 8, trans: []database.BlockTx{database.NewBlockTx(good.SignedTx, 989, 1)}, change: func(h *database.BlockHeader) {}, success: false},
+		{testCaseID: 9, trans: []database.BlockTx{database.NewBlockTx(good.SignedTx, 1, 2)}, change: func(h *database.BlockHeader) {}, success: false},
+		{testCaseID: 10, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) { h.TimeStamp = uint64(time.Now().Add(time.Hour).Unix()) }, success: false},
+		{testCaseID: 11, trans: []database.BlockTx{good}, change: func(h *database.BlockHeader) { h.TimeStamp = uint64(time.Now().Unix()) }, success: true},
+	}
+
+	for _, tt := range table {
+		tree, err := merkle.NewTree(tt.trans)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+
+		block := database.Block{
+			Header: database.BlockHeader{
+				Number:        1,
+				PrevBlockHash: signature.ZeroHash,
+				BeneficiaryID: accountID(bnfc),
+				Difficulty:    gen.Difficulty,
+				MiningReward:  gen.MiningReward,
+				TransRoot:     tree.RootHex(),
+			},
+			MerkleTree: tree,
+		}
+		tt.change(&block.Header)
+		solve(&block)
+
+		err = db.ValidateBlock(block)
+		if tt.success && err != nil {
+			t.Errorf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+		if !tt.success && err == nil {
+			t.Errorf("[case:%d] error: expected block to be invalid", tt.testCaseID)
+		}
+		if tt.kind != nil && !errors.Is(err, tt.kind) {
+			t.Errorf("[case:%d] error: expected error %v, got %v", tt.testCaseID, tt.kind, err)
+		}
+	}
+}
+
+func Test_POW(t *testing.T) {
+	from := newKey(t)
+	to := newKey(t)
+	bnfc := newKey(t)
+
+	gen := genesisFor(map[*ecdsa.PrivateKey]uint64{from: 1000})
+
+	db, err := database.New(gen, memory.New())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	trans := []database.BlockTx{
+		database.NewBlockTx(signTx(t, from, 1, accountID(to), 100, 10), 1, 1),
+		database.NewBlockTx(signTx(t, from, 3, accountID(to), 100, 10), 1, 1),
+		database.NewBlockTx(signTx(t, from, 2, accountID(to), 5000, 10), 1, 1),
+	}
+
+	header, trans, err := db.NewCandidate(accountID(bnfc), trans)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(trans) != 1 {
+		t.Fatalf("error: expected 1 applicable transaction, got %d", len(trans))
+	}
+
+	var mu sync.Mutex
+	reported := make(map[int]bool)
+	report := func(worker int, stats database.POWStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported[worker] = true
+	}
+
+	block, stats, err := database.POW(context.Background(), database.POWArgs{Header: header, Trans: trans, Workers: 4, Report: report})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if stats.Attempts == 0 {
+		t.Errorf("error: expected attempts to be reported")
+	}
+	if len(reported) != 4 {
+		t.Errorf("error: expected 4 workers to report, got %d", len(reported))
+	}
+
+	if err := db.ApplyBlock(block); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	header.Difficulty = 60
+	if _, _, err := database.POW(ctx, database.POWArgs{Header: header, Trans: trans}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error: expected mining to be cancelled, got %v", err)
+	}
+}
+
+func Test_NextDifficulty(t *testing.T) {
+	from := newKey(t)
+	to := newKey(t)
+	bnfc := newKey(t)
+
+	gen := genesisFor(map[*ecdsa.PrivateKey]uint64{from: 1000})
+	gen.TargetBlockTime = 100
+	gen.AdjustmentWindow = 3
+
+	db, err := database.New(gen, memory.New())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	// Each block is mined the specified number of seconds after the
+	// previous one and must be mined at the expected difficulty.
+	table := []struct {
+		testCaseID int
+		seconds    uint64
+		difficulty uint16
+	}{
+		{testCaseID: 1, seconds: 1, difficulty: 1},
+		{testCaseID: 2, seconds: 1, difficulty: 1},
+		{testCaseID: 3, seconds: 1, difficulty: 1},
+		{testCaseID: 4, seconds: 1000, difficulty: 2},
+		{testCaseID: 5, seconds: 1000, difficulty: 2},
+		{testCaseID: 6, seconds: 1000, difficulty: 2},
+		{testCaseID: 7, seconds: 100, difficulty: 1},
+	}
+
+	var timeStamp uint64
+	for i, tt := range table {
+		trans := []database.BlockTx{database.NewBlockTx(signTx(t, from, uint64(i+1), accountID(to), 1, 0), 1, 1)}
+
+		header, trans, err := db.NewCandidate(accountID(bnfc), trans)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+		if header.Difficulty != tt.difficulty {
+			t.Fatalf("[case:%d] error: expected difficulty %d, got %d", tt.testCaseID, tt.difficulty, header.Difficulty)
+		}
+
+		timeStamp += tt.seconds
+		header.TimeStamp = timeStamp
+
+		block, _, err := database.POW(context.Background(), database.POWArgs{Header: header, Trans: trans})
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+
+		wrong := block
+		wrong.Header.Difficulty++
+		solve(&wrong)
+		if err := db.ValidateBlock(wrong); err == nil {
+			t.Errorf("[case:%d] error: expected block with the wrong difficulty to be invalid", tt.testCaseID)
+		}
+
+		if err := db.ApplyBlock(block); err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+	}
+}
+
+func Test_GetBlocks(t *testing.T) {
+	from := newKey(t)
+	to := newKey(t)
+	bnfc := newKey(t)
+
+	gen := genesisFor(map[*ecdsa.PrivateKey]uint64{from: 1000})
+
+	db, err := database.New(gen, memory.New())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	var hashes []string
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		trans := []database.BlockTx{database.NewBlockTx(signTx(t, from, nonce, accountID(to), 10, 1), 1, 1)}
+
+		header, trans, err := db.NewCandidate(accountID(bnfc), trans)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+
+		block, _, err := database.POW(context.Background(), database.POWArgs{Header: header, Trans: trans, Workers: 1})
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+
+		if err := db.ApplyBlock(block); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		hashes = append(hashes, block.Hash())
+	}
+
+	for i, hash := range hashes {
+		num := uint64(i + 1)
+
+		blockData, err := db.GetBlock(num)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if blockData.Hash != hash || len(blockData.Trans) != 1 {
+			t.Errorf("error: expected block %d with hash %s and 1 transaction, got %s and %d", num, hash, blockData.Hash, len(blockData.Trans))
+		}
+
+		if _, err := db.GetBlockByHash(hash[2:]); err == nil {
+			t.Errorf("error: expected a hash without the 0x prefix to not be found")
+		}
+
+		blockData, err = db.GetBlockByHash(strings.ToUpper(hash))
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if blockData.Header.Number != num {
+			t.Errorf("error: expected block %d for hash %s, got %d", num, hash, blockData.Header.Number)
+		}
+	}
+
+	for _, num := range []uint64{0, 4} {
+		if _, err := db.GetBlock(num); !errors.Is(err, database.ErrBlockNotFound) {
+			t.Errorf("error: expected block %d to not be found, got %v", num, err)
+		}
+	}
+
+	table := []struct {
+		testCaseID int
+		from       uint64
+		to         uint64
+		expected   []uint64
+	}{
+		{testCaseID: 1, from: 1, to: 3, expected: []uint64{1, 2, 3}},
+		{testCaseID: 2, from: 0, to: 1, expected: []uint64{1}},
+		{testCaseID: 3, from: 2, to: 10, expected: []uint64{2, 3}},
+		{testCaseID: 4, from: 4, to: 10, expected: []uint64{}},
+	}
+
+	for _, tt := range table {
+		blocks, err := db.GetBlocks(tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+		if len(blocks) != len(tt.expected) {
+			t.Fatalf("[case:%d] error: expected %d blocks, got %d", tt.testCaseID, len(tt.expected), len(blocks))
+		}
+		for i, blockData := range blocks {
+			if blockData.Header.Number != tt.expected[i] {
+				t.Errorf("[case:%d] error: expected block %d at %d, got %d", tt.testCaseID, tt.expected[i], i, blockData.Header.Number)
+			}
+		}
+	}
+}
+
+func Test_Reorg(t *testing.T) {
+	from := newKey(t)
+	other := newKey(t)
+	to := newKey(t)
+	bnfc := newKey(t)
+
+	gen := genesisFor(map[*ecdsa.PrivateKey]uint64{from: 1000, other: 1000})
+
+	storage := memory.New()
+	db, err := database.New(gen, storage)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		mineBlock(t, db, from, nonce, accountID(to), accountID(bnfc))
+	}
+	latest := db.LatestBlock()
+	stateRoot := db.HashState()
+
+	fork, err := db.Fork(1)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	mineBlock(t, fork, other, 1, accountID(to), accountID(bnfc))
+
+	// A fork with less work than the blocks it replaces leaves the
+	// database alone.
+	if _, err := db.Reorg(fork); !errors.Is(err, database.ErrNotEnoughWork) {
+		t.Fatalf("error: expected not enough work, got %v", err)
+	}
+	if db.LatestBlock().Hash() != latest.Hash() || db.HashState() != stateRoot {
+		t.Fatalf("error: expected the database to be unchanged")
+	}
+
+	for nonce := uint64(2); nonce <= 3; nonce++ {
+		mineBlock(t, fork, other, nonce, accountID(to), accountID(bnfc))
+	}
+
+	dropped, err := db.Reorg(fork)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(dropped) != 2 || dropped[0].Header.Number != 2 || dropped[1].Header.Number != 3 {
+		t.Errorf("error: expected blocks 2 and 3 to be dropped, got %d", len(dropped))
+	}
+	if db.LatestBlock().Hash() != fork.LatestBlock().Hash() || db.HashState() != fork.HashState() {
+		t.Errorf("error: expected the database to match the fork")
+	}
+
+	// Only the blocks in the new chain can be found by hash.
+	for _, blockData := range dropped {
+		if _, err := db.GetBlockByHash(blockData.Hash); !errors.Is(err, database.ErrBlockNotFound) {
+			t.Errorf("error: expected dropped block %d to be gone, got %v", blockData.Header.Number, err)
+		}
+	}
+	blockData, err := db.GetBlockByHash(fork.LatestBlock().Hash())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if blockData.Header.Number != fork.LatestBlock().Header.Number {
+		t.Errorf("error: expected block %d, got %d", fork.LatestBlock().Header.Number, blockData.Header.Number)
+	}
+
+	// The fork's blocks are in storage and replay to the same state.
+	replay, err := database.New(gen, storage)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if replay.LatestBlock().Hash() != fork.LatestBlock().Hash() || replay.HashState() != fork.HashState() {
+		t.Errorf("error: expected the replayed database to match the fork")
+	}
+}
+
+func Test_ProveTx(t *testing.T) {
+	from := newKey(t)
+	to := newKey(t)
+
+	var trans []database.BlockTx
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		trans = append(trans, database.NewBlockTx(signTx(t, from, nonce, accountID(to), 10, 1), 1, 1))
+	}
+
+	tree, err := merkle.NewTree(trans)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	block := database.Block{
+		Header:     database.BlockHeader{Number: 1, TransRoot: tree.RootHex()},
+		MerkleTree: tree,
+	}
+
+	for _, tx := range trans {
+		leafHash, err := tx.Hash()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+
+		txProof, err := block.ProveTx(strings.ToUpper(hexutil.Encode(leafHash)))
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if !txProof.Tx.Equals(tx) {
+			t.Errorf("error: expected the proof for tx %s, got %s", tx, txProof.Tx)
+		}
+
+		root, err := hexutil.Decode(txProof.TransRoot)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if err := merkle.VerifyProof(txProof.LeafHash, txProof.Proof, txProof.Order, root, sha256.New); err != nil {
+			t.Errorf("error: expected a valid proof for tx %s: %v", tx, err)
+		}
+	}
+
+	if _, err := block.ProveTx(signature.ZeroHash); !errors.Is(err, database.ErrTxNotFound) {
+		t.Errorf("error: expected an unknown transaction to not be found, got %v", err)
+	}
+}
+
+// =============================================================================
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("error: generating key: %v", err)
+	}
+
+	return privateKey
+}
+
+func accountID(privateKey *ecdsa.PrivateKey) database.AccountID {
+	return database.AccountID(crypto.PubkeyToAddress(privateKey.PublicKey).String())
+}
+
+func genesisFor(balances map[*ecdsa.PrivateKey]uint64) genesis.Genesis {
+	gen := genesis.Genesis{
+		ChainID:       1,
+		TransPerBlock: 10,
+		Difficulty:    1,
+		MiningReward:  700,
+		GasPrice:      1,
+		Balances:      make(map[string]uint64),
+	}
+
+	for privateKey, balance := range balances {
+		gen.Balances[string(accountID(privateKey))] = balance
+	}
+
+	return gen
+}
+
+// mineBlock mines a block holding a single transaction and applies it.
+func mineBlock(t *testing.T, db *database.Database, privateKey *ecdsa.PrivateKey, nonce uint64, toID database.AccountID, bnfcID database.AccountID) database.Block {
+	t.Helper()
+
+	trans := []database.BlockTx{database.NewBlockTx(signTx(t, privateKey, nonce, toID, 10, 1), 1, 1)}
+
+	header, trans, err := db.NewCandidate(bnfcID, trans)
+	if err != nil {
+		t.Fatalf("error: new candidate: %v", err)
+	}
+
+	block, _, err := database.POW(context.Background(), database.POWArgs{Header: header, Trans: trans, Workers: 1})
+	if err != nil {
+		t.Fatalf("error: mining block: %v", err)
+	}
+
+	if err := db.ApplyBlock(block); err != nil {
+		t.Fatalf("error: applying block: %v", err)
+	}
+
+	return block
+}
+
+// solve finds a nonce for the block that satisfies its difficulty.
+func solve(block *database.Block) {
+	for block.Header.Nonce = 0; ; block.Header.Nonce++ {
+		if strings.HasPrefix(block.Hash(), "0x"+strings.Repeat("0", int(block.Header.Difficulty))) {
+			return
+		}
+	}
+}
+
+func signTx(t *testing.T, privateKey *ecdsa.PrivateKey, nonce uint64, toID database.AccountID, value uint64, tip uint64) database.SignedTx {
+	t.Helper()
+
+	tx, err := database.NewTx(1, nonce, toID, value, tip, nil)
+	if err != nil {
+		t.Fatalf("error: constructing tx: %v", err)
+	}
+
+	signedTx, err := tx.Sign(privateKey)
+	if err != nil {
+		t.Fatalf("error: signing tx: %v", err)
+	}
+
+	return signedTx
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"fmt"
+)
+
+// The difficulty is the number of leading zero hex digits in the block hash,
+// so every step up or down changes the expected work by a factor of 16. To
+// stop the difficulty from bouncing between two values, it only moves when
+// the blocks in the last window came in more than retargetFactor times
+// faster or slower than the target.
+const (
+	retargetFactor = 4
+	minDifficulty  = 1
+	maxDifficulty  = 64
+)
+
+// NextDifficulty returns the difficulty the next block must be mined with.
+func (db *Database) NextDifficulty() (uint16, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.nextDifficulty()
+}
+
+// nextDifficulty calculates the difficulty for the block after the latest
+// block. The difficulty only changes on the first block after each full
+// adjustment window, based on how long the blocks in that window took to
+// mine. The caller must hold the lock.
+func (db *Database) nextDifficulty() (uint16, error) {
+	latest := db.latest
+	window := db.genesis.AdjustmentWindow
+
+	// Use the genesis difficulty when retargeting is turned off or until
+	// the first window of blocks has been mined.
+	if db.genesis.TargetBlockTime == 0 || window < 2 || latest.Header.Number < window {
+		return db.genesis.Difficulty, nil
+	}
+
+	// Keep the latest difficulty inside a window.
+	if latest.Header.Number%window != 0 {
+		return latest.Header.Difficulty, nil
+	}
+
+	firstData, err := db.storage.GetBlock(latest.Header.Number - window + 1)
+	if err != nil {
+		return 0, fmt.Errorf("retarget, block %d: %w", latest.Header.Number-window+1, err)
+	}
+
+	var actual uint64
+	if latest.Header.TimeStamp > firstData.Header.TimeStamp {
+		actual = latest.Header.TimeStamp - firstData.Header.TimeStamp
+	}
+	expected := db.genesis.TargetBlockTime * (window - 1)
+
+	return retarget(latest.Header.Difficulty, actual, expected), nil
+}
+
+// retarget adjusts the difficulty based on the actual time it took to mine
+// a window of blocks compared to the expected time.
+func retarget(difficulty uint16, actual uint64, expected uint64) uint16 {
+	switch {
+	case actual*retargetFactor < expected && difficulty < maxDifficulty:
+		return difficulty + 1
+
+	case actual > expected*retargetFactor && difficulty > minDifficulty:
+		return difficulty - 1
+	}
+
+	return difficulty
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// Fork constructs a copy of the database as of the specified block number by
+// replaying the stored blocks up to that number. Blocks applied to the fork
+// are fully validated the same way as any other block, but they are held in
+// memory and this database is left alone until the fork is passed to Reorg.
+func (db *Database) Fork(num uint64) (*Database, error) {
+	latest := db.LatestBlock()
+	if num > latest.Header.Number {
+		return nil, fmt.Errorf("fork at %d: %w", num, ErrBlockNotFound)
+	}
+
+	var baseHash string
+	if num > 0 {
+		blockData, err := db.storage.GetBlock(num)
+		if err != nil {
+			return nil, fmt.Errorf("fork at %d: %w", num, err)
+		}
+		baseHash = blockData.Hash
+	}
+
+	storage := forkStorage{
+		base:     db.storage,
+		baseNum:  num,
+		baseHash: baseHash,
+		blocks:   make(map[uint64]BlockData),
+	}
+
+	fork, err := New(db.genesis, &storage)
+	if err != nil {
+		return nil, fmt.Errorf("fork at %d: %w", num, err)
+	}
+
+	return fork, nil
+}
+
+// Reorg replaces the blocks after the block the fork was taken at with the
+// blocks applied to the fork, provided the fork holds more work than this
+// database does after that block. The blocks that are no longer part of the
+// chain are returned so their transactions can be put back in the mempool.
+func (db *Database) Reorg(fork *Database) ([]BlockData, error) {
+	storage, ok := fork.storage.(*forkStorage)
+	if !ok {
+		return nil, errors.New("database is not a fork")
+	}
+
+	fork.mu.RLock()
+	defer fork.mu.RUnlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// The chain may have moved on since the fork was taken, so make sure the
+	// fork still builds on a block in this chain.
+	if storage.baseNum > db.latest.Header.Number {
+		return nil, fmt.Errorf("fork at %d is ahead of the latest block %d", storage.baseNum, db.latest.Header.Number)
+	}
+	if storage.baseNum > 0 {
+		blockData, err := db.storage.GetBlock(storage.baseNum)
+		if err != nil {
+			return nil, fmt.Errorf("fork at %d: %w", storage.baseNum, err)
+		}
+		if blockData.Hash != storage.baseHash {
+			return nil, fmt.Errorf("fork at %d no longer builds on this chain", storage.baseNum)
+		}
+	}
+
+	dropped := make([]BlockData, 0, db.latest.Header.Number-storage.baseNum)
+	for num := storage.baseNum + 1; num <= db.latest.Header.Number; num++ {
+		blockData, err := db.storage.GetBlock(num)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", num, err)
+		}
+		dropped = append(dropped, blockData)
+	}
+
+	if chainWork(storage.added()).Cmp(chainWork(dropped)) <= 0 {
+		return nil, ErrNotEnoughWork
+	}
+
+	// The new blocks are written over the old ones before anything past the
+	// end of the new chain is removed, so storage is never left without a
+	// chain to replay.
+	for _, blockData := range storage.added() {
+		if err := db.storage.Write(blockData); err != nil {
+			return nil, fmt.Errorf("block %d, write: %w", blockData.Header.Number, err)
+		}
+	}
+
+	if err := db.storage.Truncate(fork.latest.Header.Number); err != nil {
+		return nil, fmt.Errorf("truncate: %w", err)
+	}
+
+	accounts := make(map[AccountID]Account, len(fork.accounts))
+	for accountID, account := range fork.accounts {
+		accounts[accountID] = account
+	}
+
+	for _, blockData := range dropped {
+		delete(db.hashes, hashKey(blockData.Hash))
+	}
+	for _, blockData := range storage.added() {
+		db.hashes[hashKey(blockData.Hash)] = blockData.Header.Number
+	}
+
+	db.accounts = accounts
+	db.stateRoot = fork.stateRoot
+	db.latest = fork.latest
+
+	return dropped, nil
+}
+
+// =============================================================================
+
+// chainWork returns the total work that went into mining the blocks. Each
+// difficulty step is one more leading zero hex digit in the hash, so a block
+// takes 16^difficulty hashes on average to mine.
+func chainWork(blocks []BlockData) *big.Int {
+	work := new(big.Int)
+	for _, blockData := range blocks {
+		work.Add(work, new(big.Int).Lsh(big.NewInt(1), 4*uint(blockData.Header.Difficulty)))
+	}
+
+	return work
+}
+
+// =============================================================================
+
+// forkStorage reads the blocks up to the block the fork was taken at from the
+// storage of the original database, and holds the blocks written to the fork
+// in memory. This implements the Storage interface.
+type forkStorage struct {
+	mu       sync.RWMutex
+	base     Storage
+	baseNum  uint64
+	baseHash string
+	blocks   map[uint64]BlockData
+}
+
+// Close in this implementation has nothing to do.
+func (fs *forkStorage) Close() error {
+	return nil
+}
+
+// Write holds the block in memory.
+func (fs *forkStorage) Write(blockData BlockData) error {
+	if blockData.Header.Number <= fs.baseNum {
+		return fmt.Errorf("block %d is before the fork at %d", blockData.Header.Number, fs.baseNum)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.blocks[blockData.Header.Number] = blockData
+
+	return nil
+}
+
+// GetBlock returns the specified block by number.
+func (fs *forkStorage) GetBlock(num uint64) (BlockData, error) {
+	if num <= fs.baseNum {
+		return fs.base.GetBlock(num)
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	blockData, exists := fs.blocks[num]
+	if !exists {
+		return BlockData{}, ErrBlockNotFound
+	}
+
+	return blockData, nil
+}
+
+// ForEach returns an iterator to walk through all the blocks starting with
+// block number 1.
+func (fs *forkStorage) ForEach() Iterator {
+	return &forkIterator{storage: fs}
+}
+
+// Reset clears out the blocks written to the fork.
+func (fs *forkStorage) Reset() error {
+	return fs.Truncate(fs.baseNum)
+}
+
+// Truncate removes every block written to the fork after the specified
+// block number.
+func (fs *forkStorage) Truncate(num uint64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for n := range fs.blocks {
+		if n > num {
+			delete(fs.blocks, n)
+		}
+	}
+
+	return nil
+}
+
+// added returns the blocks written to the fork in order.
+func (fs *forkStorage) added() []BlockData {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	blocks := make([]BlockData, 0, len(fs.blocks))
+	for num := fs.baseNum + 1; ; num++ {
+		blockData, exists := fs.blocks[num]
+		if !exists {
+			return blocks
+		}
+		blocks = append(blocks, blockData)
+	}
+}
+
+// forkIterator represents the iteration implementation for walking through
+// the blocks of a fork. This implements the Iterator interface.
+type forkIterator struct {
+	storage *forkStorage // Access to the storage API.
+	current uint64       // Currently loaded block.
+	eoc     bool         // Represents the end of the chain.
+}
+
+// Next retrieves the next block, stopping at the block the fork was taken
+// at until blocks are written to the fork.
+func (fi *forkIterator) Next() (BlockData, error) {
+	if fi.eoc {
+		return BlockData{}, errors.New("end of chain")
+	}
+
+	fi.current++
+	blockData, err := fi.storage.GetBlock(fi.current)
+	if err != nil {
+		fi.eoc = true
+	}
+
+	return blockData, err
+}
+
+// Done returns the end of chain value.
+func (fi *forkIterator) Done() bool {
+	return fi.eoc
+}
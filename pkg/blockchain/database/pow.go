@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/merkle"
+)
+
+// reportEvery is the number of attempts between progress reports from a
+// mining worker.
+const reportEvery = 100_000
+
+// POWArgs represents the set of arguments required to run POW.
+type POWArgs struct {
+	Header  BlockHeader
+	Trans   []BlockTx
+	Workers int
+	Report  func(worker int, stats POWStats)
+}
+
+// POWStats represents the amount of work performed by a POW search.
+type POWStats struct {
+	Attempts uint64
+	Duration time.Duration
+}
+
+// HashRate returns the number of hashes calculated per second.
+func (s POWStats) HashRate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+
+	return float64(s.Attempts) / s.Duration.Seconds()
+}
+
+// POW constructs a new Block from the candidate header and transactions and
+// performs the work to find a nonce that solves the cryptographic POW puzzle.
+// The search is split across the number of workers specified, each scanning
+// a disjoint set of nonces, and the first worker to find a solution stops
+// the others. The search also stops when the context is cancelled, which is
+// how mining is abandoned when a competing block for the same height arrives.
+func POW(ctx context.Context, args POWArgs) (Block, POWStats, error) {
+	block := Block{
+		Header: args.Header,
+	}
+
+	if len(args.Trans) > 0 {
+		tree, err := merkle.NewTree(args.Trans)
+		if err != nil {
+			return Block{}, POWStats{}, err
+		}
+		block.MerkleTree = tree
+	}
+	block.Header.TransRoot = block.transRoot()
+
+	workers := args.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Choose a random starting point for the nonce so miners working on
+	// the same header don't repeat each other's work. Worker i takes the
+	// nonces base+i, base+i+workers, base+i+2*workers and so on.
+	base := rand.Uint64()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var total POWStats
+	var solved *Block
+
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			b, stats, ok := search(ctx, block, base+uint64(worker), uint64(workers), func(stats POWStats) {
+				if args.Report != nil {
+					args.Report(worker, stats)
+				}
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			total.Attempts += stats.Attempts
+			if ok && solved == nil {
+				solved = &b
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	total.Duration = time.Since(start)
+
+	if solved == nil {
+		return Block{}, total, ctx.Err()
+	}
+
+	return *solved, total, nil
+}
+
+// search looks for a nonce that solves the block's difficulty starting at the
+// specified nonce and moving forward by step. It reports the work performed
+// periodically and when the search finishes.
+func search(ctx context.Context, block Block, nonce uint64, step uint64, report func(stats POWStats)) (Block, POWStats, bool) {
+	var stats POWStats
+	start := time.Now()
+
+	defer func() {
+		stats.Duration = time.Since(start)
+		report(stats)
+	}()
+
+	for block.Header.Nonce = nonce; ; block.Header.Nonce += step {
+		stats.Attempts++
+
+		if isHashSolved(block.Header.Difficulty, block.Hash()) {
+			return block, stats, true
+		}
+
+		// Don't check the context on every attempt, it's more expensive
+		// than the hash itself.
+		if stats.Attempts%1_000 == 0 {
+			if ctx.Err() != nil {
+				return Block{}, stats, false
+			}
+		}
+
+		if stats.Attempts%reportEvery == 0 {
+			stats.Duration = time.Since(start)
+			report(stats)
+		}
+	}
+}
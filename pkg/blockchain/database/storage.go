@@ -0,0 +1,19 @@
+package database
+
+// Storage interface represents the behavior required to be implemented by any
+// package providing support for reading and writing the blockchain.
+type Storage interface {
+	Write(blockData BlockData) error
+	GetBlock(num uint64) (BlockData, error)
+	ForEach() Iterator
+	Close() error
+	Reset() error
+	Truncate(num uint64) error
+}
+
+// Iterator interface represents the behavior required to be implemented by any
+// package providing support to iterate over the blocks in order.
+type Iterator interface {
+	Next() (BlockData, error)
+	Done() bool
+}
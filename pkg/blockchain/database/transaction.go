@@ -7,11 +7,18 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"math/bits"
 	"time"
 
 	"github.com/sphierex/blockchain/pkg/blockchain/signature"
 )
 
+// ErrOverflow is returned when an amount doesn't fit in a uint64.
+var ErrOverflow = errors.New("amount overflows")
+
+// OneUnitOfGas is the number of gas units charged for every transaction.
+const OneUnitOfGas = 1
+
 // ============================================================================
 
 // Tx is the transactional information between two parties.
@@ -87,6 +94,10 @@ func (tx SignedTx) Validate() error {
 		return errors.New("invalid account for to account")
 	}
 
+	if tx.V == nil || tx.R == nil || tx.S == nil {
+		return errors.New("missing signature")
+	}
+
 	if err := signature.VerifySignature(tx.Tx, tx.V, tx.R, tx.S); err != nil {
 		return err
 	}
@@ -136,6 +147,33 @@ func NewBlockTx(signedTx SignedTx, gasPrice uint64, unitsOfGas uint64) BlockTx {
 	}
 }
 
+// GasFee returns the gas price multiplied by the gas units, failing when
+// the fee doesn't fit in a uint64.
+func (tx BlockTx) GasFee() (uint64, error) {
+	hi, fee := bits.Mul64(tx.GasPrice, tx.GasUnits)
+	if hi != 0 {
+		return 0, fmt.Errorf("gas fee: %w", ErrOverflow)
+	}
+
+	return fee, nil
+}
+
+// Cost returns the total the sender pays for the transaction, which is the
+// value, tip and gas fee, failing when the total doesn't fit in a uint64.
+func (tx BlockTx) Cost() (uint64, error) {
+	fee, err := tx.GasFee()
+	if err != nil {
+		return 0, err
+	}
+
+	cost, err := AddAmounts(tx.Value, tx.Tip, fee)
+	if err != nil {
+		return 0, fmt.Errorf("cost: %w", err)
+	}
+
+	return cost, nil
+}
+
 // Hash implements the markle Hashable interface for providing a hash
 // of a block transaction.
 func (tx BlockTx) Hash() ([]byte, error) {
@@ -154,3 +192,20 @@ func (tx BlockTx) Equals(otherTx BlockTx) bool {
 
 	return tx.Nonce == otherTx.Nonce && bytes.Equal(txSig, otherTxSig)
 }
+
+// =============================================================================
+
+// AddAmounts returns the sum of the amounts, failing with ErrOverflow when
+// the sum doesn't fit in a uint64.
+func AddAmounts(amounts ...uint64) (uint64, error) {
+	var sum uint64
+	for _, amount := range amounts {
+		var carry uint64
+		sum, carry = bits.Add64(sum, amount, 0)
+		if carry != 0 {
+			return 0, ErrOverflow
+		}
+	}
+
+	return sum, nil
+}
@@ -6,15 +6,20 @@ import (
 	"time"
 )
 
-// Genesis represents the genesis file.
+// Genesis represents the genesis file. The difficulty is the starting
+// difficulty, which is retargeted every adjustment window of blocks to keep
+// the time between blocks close to the target block time in seconds. A zero
+// target block time or adjustment window keeps the difficulty fixed.
 type Genesis struct {
-	Date          time.Time         `json:"date"`
-	ChainID       uint16            `json:"chain_id"`
-	TransPerBlock uint16            `json:"trans_per_block"`
-	Difficulty    uint16            `json:"difficulty"`
-	MiningReward  uint64            `json:"mining_reward"`
-	GasPrice      uint64            `json:"gas_price"`
-	Balances      map[string]uint64 `json:"balances"`
+	Date             time.Time         `json:"date"`
+	ChainID          uint16            `json:"chain_id"`
+	TransPerBlock    uint16            `json:"trans_per_block"`
+	Difficulty       uint16            `json:"difficulty"`
+	TargetBlockTime  uint64            `json:"target_block_time"`
+	AdjustmentWindow uint64            `json:"adjustment_window"`
+	MiningReward     uint64            `json:"mining_reward"`
+	GasPrice         uint64            `json:"gas_price"`
+	Balances         map[string]uint64 `json:"balances"`
 }
 
 // Load opens and consumes the genesis file.
@@ -0,0 +1,157 @@
+package mempool
+
+import (
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// account holds the transactions in the mempool for a single account.
+type account struct {
+	nonce  uint64                      // Nonce of the last transaction applied to the blockchain.
+	ready  []database.BlockTx          // Transactions with nonces following on from nonce.
+	future map[uint64]database.BlockTx // Transactions with nonces beyond a gap.
+}
+
+// newAccount constructs an account with no transactions.
+func newAccount(nonce uint64) *account {
+	return &account{
+		nonce:  nonce,
+		future: make(map[uint64]database.BlockTx),
+	}
+}
+
+// count returns the number of transactions held for the account.
+func (a *account) count() int {
+	return len(a.ready) + len(a.future)
+}
+
+// nextNonce returns the nonce the next ready transaction must have.
+func (a *account) nextNonce() uint64 {
+	return a.nonce + uint64(len(a.ready)) + 1
+}
+
+// all returns every transaction held for the account.
+func (a *account) all() []database.BlockTx {
+	trans := append([]database.BlockTx(nil), a.ready...)
+	for _, tx := range a.future {
+		trans = append(trans, tx)
+	}
+
+	return trans
+}
+
+// last returns the transaction with the highest nonce and if it's a
+// future transaction.
+func (a *account) last() (database.BlockTx, bool, bool) {
+	var last database.BlockTx
+	var found bool
+	for n, tx := range a.future {
+		if !found || n > last.Nonce {
+			last = tx
+			found = true
+		}
+	}
+
+	if found {
+		return last, true, true
+	}
+
+	if len(a.ready) == 0 {
+		return database.BlockTx{}, false, false
+	}
+
+	return a.ready[len(a.ready)-1], false, true
+}
+
+// get returns the transaction with the specified nonce.
+func (a *account) get(nonce uint64) (database.BlockTx, bool) {
+	if idx, ok := a.readyIndex(nonce); ok {
+		return a.ready[idx], true
+	}
+
+	tx, exists := a.future[nonce]
+	return tx, exists
+}
+
+// replace swaps the transaction holding the same nonce.
+func (a *account) replace(tx database.BlockTx) {
+	if idx, ok := a.readyIndex(tx.Nonce); ok {
+		a.ready[idx] = tx
+		return
+	}
+
+	a.future[tx.Nonce] = tx
+}
+
+// push appends the next ready transaction and then promotes any future
+// transactions that now follow on.
+func (a *account) push(tx database.BlockTx) {
+	a.ready = append(a.ready, tx)
+	a.promote()
+}
+
+// remove takes the transaction with the specified nonce out of the account.
+// Ready transactions after it are moved back to the future queue.
+func (a *account) remove(nonce uint64) {
+	if _, exists := a.future[nonce]; exists {
+		delete(a.future, nonce)
+		return
+	}
+
+	idx, ok := a.readyIndex(nonce)
+	if !ok {
+		return
+	}
+
+	for _, tx := range a.ready[idx+1:] {
+		a.future[tx.Nonce] = tx
+	}
+	a.ready = a.ready[:idx]
+}
+
+// advance moves the account nonce forward, removing the transactions that
+// can no longer be mined and promoting the future transactions that follow
+// on. The removed transactions are returned.
+func (a *account) advance(nonce uint64) []database.BlockTx {
+	if nonce <= a.nonce {
+		return nil
+	}
+
+	drop := int(min(nonce-a.nonce, uint64(len(a.ready))))
+	stale := append([]database.BlockTx(nil), a.ready[:drop]...)
+	a.ready = a.ready[drop:]
+
+	for n, tx := range a.future {
+		if n <= nonce {
+			stale = append(stale, tx)
+			delete(a.future, n)
+		}
+	}
+
+	a.nonce = nonce
+	a.promote()
+
+	return stale
+}
+
+// promote moves future transactions that follow on from the ready
+// transactions into the ready list.
+func (a *account) promote() {
+	for {
+		tx, exists := a.future[a.nextNonce()]
+		if !exists {
+			return
+		}
+
+		delete(a.future, tx.Nonce)
+		a.ready = append(a.ready, tx)
+	}
+}
+
+// readyIndex returns the index in the ready list for the specified nonce.
+func (a *account) readyIndex(nonce uint64) (int, bool) {
+	if nonce <= a.nonce || nonce >= a.nextNonce() {
+		return 0, false
+	}
+
+	return int(nonce - a.nonce - 1), true
+}
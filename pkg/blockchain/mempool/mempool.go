@@ -0,0 +1,499 @@
+// Package mempool maintains the mempool for the blockchain.
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sphierex/blockchain/internal/web/metrics"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/mempool/selector"
+	"go.uber.org/zap"
+)
+
+// Set of errors returned when a transaction can't be added to the mempool.
+var (
+	ErrNonceTooLow        = errors.New("nonce has already been used")
+	ErrAlreadyKnown       = errors.New("transaction already in mempool")
+	ErrReplaceUnderpriced = errors.New("replacement transaction tip is too low")
+	ErrFutureLimit        = errors.New("too many future transactions for account")
+	ErrAccountLimit       = errors.New("too many transactions for account")
+	ErrMempoolFull        = errors.New("mempool is full and the tip is too low")
+)
+
+// Default values used when the options are not provided.
+const (
+	defaultReplaceBump   = 10
+	defaultMaxFuture     = 16
+	defaultMaxCount      = 10_000
+	defaultMaxPerAccount = 64
+	defaultTTL           = 3 * time.Hour
+)
+
+// Set of reasons transactions are evicted from the mempool.
+const (
+	evictFull    = "full"
+	evictExpired = "expired"
+	evictStale   = "stale"
+)
+
+// Mempool represents a cache of transactions organized by account. The
+// transactions an account can execute next, in nonce order, are ready to
+// be mined. Transactions with a nonce beyond a gap are held in a future
+// queue until the gap is filled.
+type Mempool struct {
+	mu            sync.RWMutex
+	accounts      map[database.AccountID]*account
+	strategy      string
+	selectFn      selector.Func
+	replaceBump   uint64
+	maxFuture     int
+	maxCount      int
+	maxPerAccount int
+	ttl           time.Duration
+	log           *zap.SugaredLogger
+}
+
+// WithStrategy is used to change the default select strategy of picking
+// transactions by tip when constructing a new mempool.
+func WithStrategy(strategy string) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.strategy = strategy
+	}
+}
+
+// WithReplaceBump is used to change the percentage a replacement transaction
+// must raise the tip by to replace a transaction with the same nonce.
+func WithReplaceBump(percent uint64) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.replaceBump = percent
+	}
+}
+
+// WithMaxFuture is used to change the number of future transactions a
+// single account can hold in the mempool.
+func WithMaxFuture(maxFuture int) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.maxFuture = maxFuture
+	}
+}
+
+// WithMaxCount is used to change the number of transactions the mempool
+// can hold. Once full, the transactions with the lowest tip are evicted to
+// make room for transactions with a better tip.
+func WithMaxCount(maxCount int) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.maxCount = maxCount
+	}
+}
+
+// WithMaxPerAccount is used to change the number of transactions a single
+// account can hold in the mempool.
+func WithMaxPerAccount(maxPerAccount int) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.maxPerAccount = maxPerAccount
+	}
+}
+
+// WithTTL is used to change how long a transaction can stay in the mempool
+// after it was received before it is dropped.
+func WithTTL(ttl time.Duration) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.ttl = ttl
+	}
+}
+
+// WithLogger is used to log the transactions evicted from the mempool.
+func WithLogger(log *zap.SugaredLogger) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.log = log
+	}
+}
+
+// New constructs a new mempool using the select strategy provided by the
+// options, or the tip strategy by default.
+func New(options ...func(mp *Mempool)) (*Mempool, error) {
+	mp := Mempool{
+		accounts:      make(map[database.AccountID]*account),
+		strategy:      selector.StrategyTip,
+		replaceBump:   defaultReplaceBump,
+		maxFuture:     defaultMaxFuture,
+		maxCount:      defaultMaxCount,
+		maxPerAccount: defaultMaxPerAccount,
+		ttl:           defaultTTL,
+		log:           zap.NewNop().Sugar(),
+	}
+
+	for _, option := range options {
+		option(&mp)
+	}
+
+	selectFn, err := selector.Retrieve(mp.strategy)
+	if err != nil {
+		return nil, err
+	}
+	mp.selectFn = selectFn
+
+	return &mp, nil
+}
+
+// Strategy returns the name of the select strategy in use.
+func (mp *Mempool) Strategy() string {
+	return mp.strategy
+}
+
+// Count returns the current number of transaction in the pool.
+func (mp *Mempool) Count() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.count()
+}
+
+// HasReady returns true when at least one transaction is ready to be mined,
+// without running the select strategy.
+func (mp *Mempool) HasReady() bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	for _, acct := range mp.accounts {
+		if len(acct.ready) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Upsert adds a transaction to the mempool. The account nonce is the nonce
+// of the last transaction for the sending account applied to the blockchain.
+// A transaction with the same nonce as one already in the mempool replaces it
+// only if it raises the tip by more than the replace bump percentage. When
+// the mempool is full, the transaction with the lowest tip is evicted if the
+// new transaction has a better tip.
+func (mp *Mempool) Upsert(tx database.BlockTx, accountNonce uint64) error {
+	from, err := tx.FromAccount()
+	if err != nil {
+		return err
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire()
+
+	return mp.upsert(from, tx, accountNonce)
+}
+
+// Rebuild adds the transactions in the mempool back again against the
+// current account nonces, which is needed when the blockchain is reorganized
+// and the nonces can go backwards. The specified transactions from blocks
+// that are no longer part of the blockchain are added along with them, and
+// the account nonce function is called once for each sending account.
+// Transactions from the mempool that can no longer be mined are evicted.
+func (mp *Mempool) Rebuild(trans []database.BlockTx, accountNonce func(database.AccountID) uint64) {
+	type entry struct {
+		tx     database.BlockTx
+		pooled bool
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.expire()
+
+	entries := make([]entry, 0, len(trans)+mp.count())
+	for _, tx := range trans {
+		entries = append(entries, entry{tx: tx})
+	}
+	for _, acct := range mp.accounts {
+		for _, tx := range acct.all() {
+			entries = append(entries, entry{tx: tx, pooled: true})
+		}
+	}
+
+	// Adding the transactions in nonce order keeps them out of the future
+	// queue as much as possible.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].tx.Nonce < entries[j].tx.Nonce
+	})
+
+	mp.accounts = make(map[database.AccountID]*account)
+
+	nonces := make(map[database.AccountID]uint64)
+	var stale []database.BlockTx
+	for _, e := range entries {
+		from, err := e.tx.FromAccount()
+		if err != nil {
+			continue
+		}
+
+		nonce, exists := nonces[from]
+		if !exists {
+			nonce = accountNonce(from)
+			nonces[from] = nonce
+		}
+
+		if err := mp.upsert(from, e.tx, nonce); err != nil && e.pooled && !errors.Is(err, ErrAlreadyKnown) {
+			stale = append(stale, e.tx)
+		}
+	}
+
+	mp.evicted(evictStale, stale)
+}
+
+// upsert adds the transaction from the specified account to the mempool.
+// The caller must hold the lock.
+func (mp *Mempool) upsert(from database.AccountID, tx database.BlockTx, accountNonce uint64) error {
+	acct, exists := mp.accounts[from]
+	if !exists {
+		acct = newAccount(accountNonce)
+		mp.accounts[from] = acct
+	}
+	mp.evicted(evictStale, acct.advance(accountNonce))
+
+	if tx.Nonce <= acct.nonce {
+		mp.dropEmpty(from)
+		return fmt.Errorf("%w, got %d, exp > %d", ErrNonceTooLow, tx.Nonce, acct.nonce)
+	}
+
+	if old, exists := acct.get(tx.Nonce); exists {
+		if old.Equals(tx) {
+			return ErrAlreadyKnown
+		}
+
+		if !tipBumped(tx.Tip, old.Tip, mp.replaceBump) {
+			return fmt.Errorf("%w, got %d, must be more than %d%% over %d", ErrReplaceUnderpriced, tx.Tip, mp.replaceBump, old.Tip)
+		}
+
+		acct.replace(tx)
+		return nil
+	}
+
+	if acct.count() >= mp.maxPerAccount {
+		return fmt.Errorf("%w, max %d", ErrAccountLimit, mp.maxPerAccount)
+	}
+
+	ready := tx.Nonce == acct.nextNonce()
+	if !ready && len(acct.future) >= mp.maxFuture {
+		mp.dropEmpty(from)
+		return fmt.Errorf("%w, max %d", ErrFutureLimit, mp.maxFuture)
+	}
+
+	if mp.count() >= mp.maxCount {
+		if err := mp.evictLowest(from, tx.Tip); err != nil {
+			mp.dropEmpty(from)
+			return err
+		}
+	}
+
+	if ready {
+		acct.push(tx)
+		return nil
+	}
+	acct.future[tx.Nonce] = tx
+
+	return nil
+}
+
+// Delete removes a transaction from the mempool. Any ready transactions
+// for the account with a higher nonce are moved back to the future queue
+// since they can't be mined until the gap is filled again.
+func (mp *Mempool) Delete(tx database.BlockTx) error {
+	from, err := tx.FromAccount()
+	if err != nil {
+		return err
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if acct, exists := mp.accounts[from]; exists {
+		if old, exists := acct.get(tx.Nonce); exists && old.Equals(tx) {
+			acct.remove(tx.Nonce)
+			mp.dropEmpty(from)
+		}
+	}
+
+	return nil
+}
+
+// SetNonce records the nonce of the last transaction for the account that was
+// applied to the blockchain. Transactions with that nonce or lower can never
+// be mined and are evicted, and future transactions that are now next in
+// line become ready. Mined transactions should be deleted before calling
+// this so only the stale transactions are evicted. The evicted transactions
+// are returned.
+func (mp *Mempool) SetNonce(accountID database.AccountID, accountNonce uint64) []database.BlockTx {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	acct, exists := mp.accounts[accountID]
+	if !exists {
+		return nil
+	}
+
+	stale := acct.advance(accountNonce)
+	mp.dropEmpty(accountID)
+	mp.evicted(evictStale, stale)
+
+	return stale
+}
+
+// Truncate clears all the transactions from the pool.
+func (mp *Mempool) Truncate() {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.accounts = make(map[database.AccountID]*account)
+}
+
+// PickBest uses the configured select strategy to return the set of
+// transactions that should be mined next. Only ready transactions are
+// picked and transactions for each account are always returned in nonce
+// order. A value of 0 for howMany returns all the ready transactions.
+func (mp *Mempool) PickBest(howMany uint16) []database.BlockTx {
+	m := make(map[database.AccountID][]database.BlockTx)
+	var limit int
+	mp.mu.Lock()
+	{
+		mp.expire()
+
+		for from, acct := range mp.accounts {
+			if len(acct.ready) == 0 {
+				continue
+			}
+
+			m[from] = append([]database.BlockTx(nil), acct.ready...)
+			limit += len(acct.ready)
+		}
+	}
+	mp.mu.Unlock()
+
+	if howMany > 0 && int(howMany) < limit {
+		limit = int(howMany)
+	}
+
+	return mp.selectFn(m, limit)
+}
+
+// =============================================================================
+
+// count returns the number of transactions in the pool. The caller must
+// hold the lock.
+func (mp *Mempool) count() int {
+	var count int
+	for _, acct := range mp.accounts {
+		count += acct.count()
+	}
+
+	return count
+}
+
+// expire evicts the transactions that were received longer ago than the
+// TTL. The caller must hold the lock.
+func (mp *Mempool) expire() {
+	if mp.ttl <= 0 {
+		return
+	}
+
+	cutoff := uint64(time.Now().Add(-mp.ttl).Unix())
+
+	var expired []database.BlockTx
+	for from, acct := range mp.accounts {
+		for _, tx := range acct.all() {
+			if tx.TimeStamp < cutoff {
+				acct.remove(tx.Nonce)
+				expired = append(expired, tx)
+			}
+		}
+		mp.dropEmpty(from)
+	}
+
+	mp.evicted(evictExpired, expired)
+}
+
+// evictLowest makes room for a transaction with the specified tip by
+// evicting the transaction with the lowest tip from another account. Only
+// the last transaction of an account can be evicted, and future
+// transactions go before ready ones, so no remaining transaction is left
+// waiting on an evicted nonce. The caller must hold the lock.
+func (mp *Mempool) evictLowest(sender database.AccountID, tip uint64) error {
+	var victim database.BlockTx
+	var victimFrom database.AccountID
+	var victimFuture bool
+	var found bool
+
+	for from, acct := range mp.accounts {
+		if from == sender {
+			continue
+		}
+
+		tx, future, ok := acct.last()
+		if !ok {
+			continue
+		}
+
+		switch {
+		case !found,
+			future && !victimFuture,
+			future == victimFuture && tx.Tip < victim.Tip:
+			victim, victimFrom, victimFuture, found = tx, from, future, true
+		}
+	}
+
+	if !found || (!victimFuture && victim.Tip >= tip) {
+		return fmt.Errorf("%w, max %d", ErrMempoolFull, mp.maxCount)
+	}
+
+	mp.accounts[victimFrom].remove(victim.Nonce)
+	mp.dropEmpty(victimFrom)
+
+	mp.evicted(evictFull, []database.BlockTx{victim})
+
+	return nil
+}
+
+// evicted logs and counts the transactions evicted for the specified reason.
+func (mp *Mempool) evicted(reason string, trans []database.BlockTx) {
+	if len(trans) == 0 {
+		return
+	}
+
+	for _, tx := range trans {
+		mp.log.Infow("mempool", "status", "evicted", "reason", reason, "tx", tx, "tip", tx.Tip)
+	}
+
+	metrics.AddMempoolEvictions(reason, len(trans))
+}
+
+// dropEmpty removes the account from the mempool once it holds no
+// transactions. The caller must hold the lock.
+func (mp *Mempool) dropEmpty(accountID database.AccountID) {
+	if acct, exists := mp.accounts[accountID]; exists && acct.count() == 0 {
+		delete(mp.accounts, accountID)
+	}
+}
+
+// =============================================================================
+
+// tipBumped returns true when the new tip is more than the specified percent
+// over the old tip. Both sides are multiplied out to 128 bits so large tips
+// can't overflow and flip the result.
+func tipBumped(newTip uint64, oldTip uint64, percent uint64) bool {
+	newHi, newLo := bits.Mul64(newTip, 100)
+
+	baseHi, baseLo := bits.Mul64(oldTip, 100)
+	bumpHi, bumpLo := bits.Mul64(oldTip, percent)
+	oldLo, carry := bits.Add64(baseLo, bumpLo, 0)
+	oldHi, carry := bits.Add64(baseHi, bumpHi, carry)
+	if carry != 0 {
+		return false
+	}
+
+	return newHi > oldHi || (newHi == oldHi && newLo > oldLo)
+}
@@ -0,0 +1,287 @@
+package mempool_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/mempool"
+)
+
+func Test_PickBest(t *testing.T) {
+	key1 := newKey(t)
+	key2 := newKey(t)
+	to := accountID(newKey(t))
+
+	mp, err := mempool.New()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	trans := []database.BlockTx{
+		newTx(t, key1, 2, to, 50),
+		newTx(t, key1, 1, to, 10),
+		newTx(t, key1, 3, to, 5),
+		newTx(t, key2, 1, to, 20),
+		newTx(t, key2, 2, to, 1),
+	}
+	for _, tx := range trans {
+		if err := mp.Upsert(tx, 0); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	if mp.Count() != len(trans) {
+		t.Fatalf("error: expected %d transactions, got %d", len(trans), mp.Count())
+	}
+
+	// Every account's transactions come in nonce order and each row of
+	// transactions is ordered by tip.
+	table := []struct {
+		testCaseID int
+		howMany    uint16
+		expected   []uint64
+	}{
+		{testCaseID: 1, howMany: 1, expected: []uint64{20}},
+		{testCaseID: 2, howMany: 3, expected: []uint64{20, 10, 50}},
+		{testCaseID: 3, howMany: 0, expected: []uint64{20, 10, 50, 1, 5}},
+	}
+
+	for _, tt := range table {
+		best := mp.PickBest(tt.howMany)
+		if len(best) != len(tt.expected) {
+			t.Fatalf("[case:%d] error: expected %d transactions, got %d", tt.testCaseID, len(tt.expected), len(best))
+		}
+		for i, tx := range best {
+			if tx.Tip != tt.expected[i] {
+				t.Errorf("[case:%d] error: expected tip %d at %d, got %d", tt.testCaseID, tt.expected[i], i, tx.Tip)
+			}
+		}
+	}
+
+	if err := mp.Delete(trans[3]); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if mp.Count() != len(trans)-1 {
+		t.Errorf("error: expected %d transactions, got %d", len(trans)-1, mp.Count())
+	}
+
+	mp.Truncate()
+	if mp.Count() != 0 {
+		t.Errorf("error: expected an empty mempool, got %d", mp.Count())
+	}
+}
+
+func Test_ReplaceByFee(t *testing.T) {
+	key := newKey(t)
+	to := accountID(newKey(t))
+
+	mp, err := mempool.New(mempool.WithReplaceBump(10))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	table := []struct {
+		testCaseID int
+		nonce      uint64
+		tip        uint64
+		err        error
+		expected   uint64
+	}{
+		{testCaseID: 1, nonce: 1, tip: 100, err: nil, expected: 100},
+		{testCaseID: 2, nonce: 1, tip: 110, err: mempool.ErrReplaceUnderpriced, expected: 100},
+		{testCaseID: 3, nonce: 1, tip: 90, err: mempool.ErrReplaceUnderpriced, expected: 100},
+		{testCaseID: 4, nonce: 1, tip: 111, err: nil, expected: 111},
+		{testCaseID: 5, nonce: 0, tip: 500, err: mempool.ErrNonceTooLow, expected: 111},
+		{testCaseID: 6, nonce: 1, tip: math.MaxUint64/100 + 1, err: nil, expected: math.MaxUint64/100 + 1},
+		{testCaseID: 7, nonce: 1, tip: math.MaxUint64/100 + 2, err: mempool.ErrReplaceUnderpriced, expected: math.MaxUint64/100 + 1},
+	}
+
+	for _, tt := range table {
+		err := mp.Upsert(newTx(t, key, tt.nonce, to, tt.tip), 0)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("[case:%d] error: expected error %v, got %v", tt.testCaseID, tt.err, err)
+		}
+
+		best := mp.PickBest(0)
+		if len(best) != 1 || best[0].Tip != tt.expected {
+			t.Errorf("[case:%d] error: expected a single transaction with tip %d, got %v", tt.testCaseID, tt.expected, best)
+		}
+	}
+
+	tx := mp.PickBest(0)[0]
+	if err := mp.Upsert(tx, 0); !errors.Is(err, mempool.ErrAlreadyKnown) {
+		t.Errorf("error: expected error %v, got %v", mempool.ErrAlreadyKnown, err)
+	}
+}
+
+func Test_FutureQueue(t *testing.T) {
+	key := newKey(t)
+	to := accountID(newKey(t))
+	from := accountID(key)
+
+	mp, err := mempool.New(mempool.WithMaxFuture(2))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	// The account has already had 2 transactions mined.
+	table := []struct {
+		testCaseID int
+		nonce      uint64
+		err        error
+		ready      int
+		count      int
+	}{
+		{testCaseID: 1, nonce: 5, err: nil, ready: 0, count: 1},
+		{testCaseID: 2, nonce: 4, err: nil, ready: 0, count: 2},
+		{testCaseID: 3, nonce: 6, err: mempool.ErrFutureLimit, ready: 0, count: 2},
+		{testCaseID: 4, nonce: 2, err: mempool.ErrNonceTooLow, ready: 0, count: 2},
+		{testCaseID: 5, nonce: 3, err: nil, ready: 3, count: 3},
+		{testCaseID: 6, nonce: 6, err: nil, ready: 4, count: 4},
+	}
+
+	for _, tt := range table {
+		err := mp.Upsert(newTx(t, key, tt.nonce, to, 1), 2)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("[case:%d] error: expected error %v, got %v", tt.testCaseID, tt.err, err)
+		}
+		if ready := len(mp.PickBest(0)); ready != tt.ready {
+			t.Errorf("[case:%d] error: expected %d ready transactions, got %d", tt.testCaseID, tt.ready, ready)
+		}
+		if mp.HasReady() != (tt.ready > 0) {
+			t.Errorf("[case:%d] error: expected has ready to be %t", tt.testCaseID, tt.ready > 0)
+		}
+		if mp.Count() != tt.count {
+			t.Errorf("[case:%d] error: expected %d transactions, got %d", tt.testCaseID, tt.count, mp.Count())
+		}
+	}
+
+	// Mining nonces 3 and 4 leaves 5 and 6 ready.
+	if stale := mp.SetNonce(from, 4); len(stale) != 2 {
+		t.Errorf("error: expected 2 stale transactions, got %d", len(stale))
+	}
+	best := mp.PickBest(0)
+	if len(best) != 2 || best[0].Nonce != 5 || best[1].Nonce != 6 {
+		t.Errorf("error: expected nonces 5 and 6 to be ready, got %v", best)
+	}
+
+	// Deleting nonce 5 puts nonce 6 back in the future queue.
+	if err := mp.Delete(best[0]); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if ready := len(mp.PickBest(0)); ready != 0 || mp.Count() != 1 {
+		t.Errorf("error: expected 1 future transaction, got %d ready of %d", ready, mp.Count())
+	}
+}
+
+func Test_Limits(t *testing.T) {
+	key1 := newKey(t)
+	key2 := newKey(t)
+	key3 := newKey(t)
+	to := accountID(newKey(t))
+
+	mp, err := mempool.New(mempool.WithMaxCount(3), mempool.WithMaxPerAccount(2))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	table := []struct {
+		testCaseID int
+		key        *ecdsa.PrivateKey
+		nonce      uint64
+		tip        uint64
+		err        error
+		tips       []uint64
+	}{
+		{testCaseID: 1, key: key1, nonce: 1, tip: 10, err: nil, tips: []uint64{10}},
+		{testCaseID: 2, key: key1, nonce: 2, tip: 5, err: nil, tips: []uint64{10, 5}},
+		{testCaseID: 3, key: key1, nonce: 3, tip: 50, err: mempool.ErrAccountLimit, tips: []uint64{10, 5}},
+		{testCaseID: 4, key: key2, nonce: 1, tip: 20, err: nil, tips: []uint64{20, 10, 5}},
+		{testCaseID: 5, key: key3, nonce: 1, tip: 1, err: mempool.ErrMempoolFull, tips: []uint64{20, 10, 5}},
+		{testCaseID: 6, key: key3, nonce: 1, tip: 30, err: nil, tips: []uint64{30, 20, 10}},
+	}
+
+	for _, tt := range table {
+		err := mp.Upsert(newTx(t, tt.key, tt.nonce, to, tt.tip), 0)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("[case:%d] error: expected error %v, got %v", tt.testCaseID, tt.err, err)
+		}
+
+		best := mp.PickBest(0)
+		if len(best) != len(tt.tips) {
+			t.Fatalf("[case:%d] error: expected %d transactions, got %d", tt.testCaseID, len(tt.tips), len(best))
+		}
+		for i, tx := range best {
+			if tx.Tip != tt.tips[i] {
+				t.Errorf("[case:%d] error: expected tip %d at %d, got %d", tt.testCaseID, tt.tips[i], i, tx.Tip)
+			}
+		}
+	}
+}
+
+func Test_Expire(t *testing.T) {
+	key := newKey(t)
+	to := accountID(newKey(t))
+
+	mp, err := mempool.New(mempool.WithTTL(time.Hour))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	old := newTx(t, key, 2, to, 1)
+	old.TimeStamp = uint64(time.Now().Add(-2 * time.Hour).Unix())
+
+	trans := []database.BlockTx{newTx(t, key, 1, to, 1), old, newTx(t, key, 3, to, 1)}
+	for _, tx := range trans {
+		if err := mp.Upsert(tx, 0); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	// Nonce 2 has expired so nonce 3 is left waiting on the gap.
+	best := mp.PickBest(0)
+	if len(best) != 1 || best[0].Nonce != 1 {
+		t.Errorf("error: expected only nonce 1 to be ready, got %v", best)
+	}
+	if mp.Count() != 2 {
+		t.Errorf("error: expected 2 transactions, got %d", mp.Count())
+	}
+}
+
+// =============================================================================
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("error: generating key: %v", err)
+	}
+
+	return privateKey
+}
+
+func accountID(privateKey *ecdsa.PrivateKey) database.AccountID {
+	return database.AccountID(crypto.PubkeyToAddress(privateKey.PublicKey).String())
+}
+
+func newTx(t *testing.T, privateKey *ecdsa.PrivateKey, nonce uint64, toID database.AccountID, tip uint64) database.BlockTx {
+	t.Helper()
+
+	tx, err := database.NewTx(1, nonce, toID, 1, tip, nil)
+	if err != nil {
+		t.Fatalf("error: constructing tx: %v", err)
+	}
+
+	signedTx, err := tx.Sign(privateKey)
+	if err != nil {
+		t.Fatalf("error: signing tx: %v", err)
+	}
+
+	return database.NewBlockTx(signedTx, 1, 1)
+}
@@ -0,0 +1,14 @@
+package selector
+
+import (
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// fifoSelect returns transactions in the order they were received by the
+// node while respecting the nonce for each account/transaction. This ignores
+// the tip completely and is useful for testing fairness.
+var fifoSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+	return greedySelect(m, howMany, func(a, b database.BlockTx) bool {
+		return a.TimeStamp < b.TimeStamp
+	})
+}
@@ -0,0 +1,98 @@
+// Package selector provides different transaction selecting algorithms.
+package selector
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// List of different select strategies.
+const (
+	StrategyTip         = "tip"
+	StrategyTipAdvanced = "tip_advanced"
+	StrategyTipPerGas   = "tip_per_gas"
+	StrategyFIFO        = "fifo"
+)
+
+// Map of different select strategies with functions.
+var strategies = map[string]Func{
+	StrategyTip:         tipSelect,
+	StrategyTipAdvanced: advancedTipSelect,
+	StrategyTipPerGas:   tipPerGasSelect,
+	StrategyFIFO:        fifoSelect,
+}
+
+// Func defines a function that takes a mempool of transactions grouped by
+// account and selects howMany of them in an order based on the functions
+// strategy. The transactions for each account are provided in nonce order
+// and all selector functions MUST respect that order.
+type Func func(transactions map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx
+
+// Retrieve returns the specified select strategy function.
+func Retrieve(strategy string) (Func, error) {
+	fn, exists := strategies[strategy]
+	if !exists {
+		return nil, fmt.Errorf("strategy %q does not exist", strategy)
+	}
+
+	return fn, nil
+}
+
+// Strategies returns the sorted names of all the select strategies.
+func Strategies() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// =============================================================================
+
+// greedySelect repeatedly picks the best next transaction across all the
+// accounts using the specified comparison until howMany have been picked.
+// Only the transaction with the lowest pending nonce of an account is a
+// candidate, which keeps the nonce ordering intact.
+func greedySelect(m map[database.AccountID][]database.BlockTx, howMany int, better func(a, b database.BlockTx) bool) []database.BlockTx {
+	accounts := make([]database.AccountID, 0, len(m))
+	for from := range m {
+		accounts = append(accounts, from)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i] < accounts[j]
+	})
+
+	lists := make([][]database.BlockTx, len(accounts))
+	for i, from := range accounts {
+		lists[i] = m[from]
+	}
+
+	next := make([]int, len(lists))
+	final := []database.BlockTx{}
+
+	for len(final) < howMany {
+		best := -1
+		for i, txs := range lists {
+			if next[i] >= len(txs) {
+				continue
+			}
+
+			if best == -1 || better(txs[next[i]], lists[best][next[best]]) {
+				best = i
+			}
+		}
+
+		if best == -1 {
+			break
+		}
+
+		final = append(final, lists[best][next[best]])
+		next[best]++
+	}
+
+	return final
+}
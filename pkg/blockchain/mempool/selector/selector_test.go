@@ -0,0 +1,201 @@
+package selector_test
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/mempool/selector"
+)
+
+func Test_Strategies(t *testing.T) {
+	m := map[database.AccountID][]database.BlockTx{
+		"A": {newTx(1, 1, 1, 1), newTx(2, 100, 1, 5)},
+		"B": {newTx(1, 50, 1, 2), newTx(2, 40, 10, 3)},
+		"C": {newTx(1, 30, 1, 4)},
+	}
+
+	// The tip strategies differ on whether the low tip transaction from A
+	// is worth taking to get to the high tip transaction behind it.
+	table := []struct {
+		testCaseID int
+		strategy   string
+		howMany    int
+		expected   []uint64
+	}{
+		{testCaseID: 1, strategy: selector.StrategyTip, howMany: 3, expected: []uint64{50, 30, 1}},
+		{testCaseID: 2, strategy: selector.StrategyTipAdvanced, howMany: 3, expected: []uint64{50, 1, 100}},
+		{testCaseID: 3, strategy: selector.StrategyTipPerGas, howMany: 4, expected: []uint64{50, 30, 40, 1}},
+		{testCaseID: 4, strategy: selector.StrategyFIFO, howMany: 5, expected: []uint64{1, 50, 40, 30, 100}},
+	}
+
+	for _, tt := range table {
+		fn, err := selector.Retrieve(tt.strategy)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+
+		trans := fn(m, tt.howMany)
+		if len(trans) != len(tt.expected) {
+			t.Fatalf("[case:%d] error: expected %d transactions, got %d", tt.testCaseID, len(tt.expected), len(trans))
+		}
+		for i, tx := range trans {
+			if tx.Tip != tt.expected[i] {
+				t.Errorf("[case:%d] error: expected tip %d at %d, got %d", tt.testCaseID, tt.expected[i], i, tx.Tip)
+			}
+		}
+	}
+
+	if _, err := selector.Retrieve("unknown"); err == nil {
+		t.Errorf("error: expected an unknown strategy to fail")
+	}
+}
+
+func Test_StrategiesOverflow(t *testing.T) {
+
+	// The tips are large enough that the products and sums the strategies
+	// compare don't fit in a uint64.
+	table := []struct {
+		testCaseID int
+		strategy   string
+		m          map[database.AccountID][]database.BlockTx
+		expected   []uint64
+	}{
+		{
+			testCaseID: 1,
+			strategy:   selector.StrategyTipPerGas,
+			m: map[database.AccountID][]database.BlockTx{
+				"A": {newTx(1, 1, 4, 1)},
+				"B": {newTx(1, 1<<63, 1, 2)},
+			},
+			expected: []uint64{1 << 63, 1},
+		},
+		{
+			testCaseID: 2,
+			strategy:   selector.StrategyTipAdvanced,
+			m: map[database.AccountID][]database.BlockTx{
+				"A": {newTx(1, 1, 1, 1), newTx(2, math.MaxUint64, 1, 2)},
+				"B": {newTx(1, 10, 1, 3)},
+			},
+			expected: []uint64{1, math.MaxUint64},
+		},
+	}
+
+	for _, tt := range table {
+		fn, err := selector.Retrieve(tt.strategy)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+
+		trans := fn(tt.m, len(tt.expected))
+		if len(trans) != len(tt.expected) {
+			t.Fatalf("[case:%d] error: expected %d transactions, got %d", tt.testCaseID, len(tt.expected), len(trans))
+		}
+		for i, tx := range trans {
+			if tx.Tip != tt.expected[i] {
+				t.Errorf("[case:%d] error: expected tip %d at %d, got %d", tt.testCaseID, tt.expected[i], i, tx.Tip)
+			}
+		}
+	}
+}
+
+func Test_StrategiesAll(t *testing.T) {
+	m := make(map[database.AccountID][]database.BlockTx)
+	for a := 0; a < 300; a++ {
+		from := database.AccountID(fmt.Sprintf("%040x", a))
+		for n := uint64(1); n <= 2; n++ {
+			m[from] = append(m[from], newTx(n, uint64(a*7+int(n)*13)%97, n%3+1, uint64(a*10)+n))
+		}
+	}
+
+	// Every strategy returns all the transactions when asked for all of
+	// them, with the transactions for each account still in nonce order.
+	for i, strategy := range selector.Strategies() {
+		fn, err := selector.Retrieve(strategy)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", i, err)
+		}
+
+		trans := fn(m, 600)
+		if len(trans) != 600 {
+			t.Fatalf("[case:%d] error: %s: expected 600 transactions, got %d", i, strategy, len(trans))
+		}
+
+		nonces := make(map[uint64]uint64)
+		for _, tx := range trans {
+			account := tx.TimeStamp / 10
+			if tx.Nonce != nonces[account]+1 {
+				t.Errorf("[case:%d] error: %s: expected nonce %d for account %d, got %d", i, strategy, nonces[account]+1, account, tx.Nonce)
+			}
+			nonces[account] = tx.Nonce
+		}
+	}
+}
+
+func Benchmark_Strategies(b *testing.B) {
+	m := make(map[database.AccountID][]database.BlockTx)
+	for a := 0; a < 100; a++ {
+		from := database.AccountID(fmt.Sprintf("%040x", a))
+		for n := uint64(1); n <= 10; n++ {
+			m[from] = append(m[from], newTx(n, uint64(a*7+int(n)*13)%97, n%3+1, uint64(a*10)+n))
+		}
+	}
+
+	for _, strategy := range selector.Strategies() {
+		fn, err := selector.Retrieve(strategy)
+		if err != nil {
+			b.Fatalf("error: unexpected error: %v", err)
+		}
+
+		b.Run(strategy, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fn(m, 100)
+			}
+		})
+	}
+}
+
+// Benchmark_StrategiesMempool selects every ready transaction from a full
+// mempool, which is what happens when the whole mempool is listed.
+func Benchmark_StrategiesMempool(b *testing.B) {
+	m := make(map[database.AccountID][]database.BlockTx)
+	for a := 0; a < 5000; a++ {
+		from := database.AccountID(fmt.Sprintf("%040x", a))
+		for n := uint64(1); n <= 2; n++ {
+			m[from] = append(m[from], newTx(n, uint64(a*7+int(n)*13)%97, n%3+1, uint64(a*10)+n))
+		}
+	}
+
+	for _, strategy := range selector.Strategies() {
+		fn, err := selector.Retrieve(strategy)
+		if err != nil {
+			b.Fatalf("error: unexpected error: %v", err)
+		}
+
+		b.Run(strategy, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				fn(m, 10000)
+			}
+		})
+	}
+}
+
+// =============================================================================
+
+// newTx constructs a transaction for selection, the signature isn't
+// needed since the selectors don't look at the sender.
+func newTx(nonce uint64, tip uint64, gasUnits uint64, timeStamp uint64) database.BlockTx {
+	return database.BlockTx{
+		SignedTx: database.SignedTx{
+			Tx: database.Tx{Nonce: nonce, Tip: tip},
+			V:  big.NewInt(0),
+			R:  big.NewInt(0),
+			S:  big.NewInt(0),
+		},
+		TimeStamp: timeStamp,
+		GasUnits:  gasUnits,
+	}
+}
@@ -0,0 +1,39 @@
+package selector
+
+import (
+	"sort"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// tipSelect returns transactions with the best tip while respecting the nonce
+// for each account/transaction. Each row is made up of the next transaction
+// for every account, the row is sorted by tip, and rows are taken until
+// howMany transactions have been picked.
+var tipSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+	final := []database.BlockTx{}
+
+	for row := 0; len(final) < howMany; row++ {
+		var trans []database.BlockTx
+		for _, txs := range m {
+			if row < len(txs) {
+				trans = append(trans, txs[row])
+			}
+		}
+
+		if len(trans) == 0 {
+			break
+		}
+
+		sort.Slice(trans, func(i, j int) bool {
+			return trans[i].Tip > trans[j].Tip
+		})
+
+		if need := howMany - len(final); len(trans) > need {
+			trans = trans[:need]
+		}
+		final = append(final, trans...)
+	}
+
+	return final
+}
@@ -0,0 +1,128 @@
+package selector
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// maxAdvancedPick is the most transactions picked with dynamic programming.
+// The cost of the search grows with the number picked for every account, so
+// it's kept to the size of a block and anything past it is picked by tip.
+const maxAdvancedPick = 256
+
+// advancedTipSelect returns the set of transactions paying the highest total
+// tip while respecting the nonce for each account/transaction. Since the
+// transactions for an account must be mined in nonce order, only a prefix of
+// each account's transactions can be taken. A low tip transaction is worth
+// taking when it unlocks high tip transactions behind it, which the simpler
+// strategies miss.
+//
+// The best prefix for each account is found with dynamic programming over
+// the accounts, where best[n] is the highest total tip using n transactions.
+// The chosen transactions are then ordered by tip, again respecting nonces.
+// When more than maxAdvancedPick transactions are asked for, the rest are
+// picked the same way as the tip strategy and follow the chosen ones.
+var advancedTipSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+	pick := min(howMany, maxAdvancedPick)
+
+	taken := advancedPrefixes(m, pick)
+
+	chosen := make(map[database.AccountID][]database.BlockTx, len(taken))
+	for from, k := range taken {
+		chosen[from] = m[from][:k]
+	}
+
+	final := greedySelect(chosen, pick, func(a, b database.BlockTx) bool {
+		return a.Tip > b.Tip
+	})
+
+	if howMany <= pick {
+		return final
+	}
+
+	rest := make(map[database.AccountID][]database.BlockTx, len(m))
+	for from, txs := range m {
+		if k := taken[from]; k < len(txs) {
+			rest[from] = txs[k:]
+		}
+	}
+
+	return append(final, tipSelect(rest, howMany-len(final))...)
+}
+
+// advancedPrefixes returns how many transactions to take from the front of
+// each account to get the highest total tip with up to howMany transactions.
+func advancedPrefixes(m map[database.AccountID][]database.BlockTx, howMany int) map[database.AccountID]int {
+	accounts := make([]database.AccountID, 0, len(m))
+	for from := range m {
+		accounts = append(accounts, from)
+	}
+
+	// best[n] is the highest total tip with n transactions and take[i][n]
+	// is how many transactions account i contributes to that total.
+	best := make([]uint64, howMany+1)
+	valid := make([]bool, howMany+1)
+	valid[0] = true
+	take := make([][]int, len(accounts))
+
+	for i, from := range accounts {
+		txs := m[from]
+
+		// prefix[k] is the total tip of the first k transactions.
+		prefix := make([]uint64, len(txs)+1)
+		for k, tx := range txs {
+			prefix[k+1] = addTips(prefix[k], tx.Tip)
+		}
+
+		next := make([]uint64, howMany+1)
+		nextValid := make([]bool, howMany+1)
+		take[i] = make([]int, howMany+1)
+
+		for n := 0; n <= howMany; n++ {
+			for k := 0; k <= len(txs) && k <= n; k++ {
+				if !valid[n-k] {
+					continue
+				}
+
+				total := addTips(best[n-k], prefix[k])
+				if !nextValid[n] || total > next[n] {
+					next[n] = total
+					nextValid[n] = true
+					take[i][n] = k
+				}
+			}
+		}
+
+		best, valid = next, nextValid
+	}
+
+	// Tips are never negative so the highest total always uses as many
+	// transactions as possible.
+	n := howMany
+	for n > 0 && !valid[n] {
+		n--
+	}
+
+	// Walk back through the accounts to find the prefix taken for each.
+	taken := make(map[database.AccountID]int, len(accounts))
+	for i := len(accounts) - 1; i >= 0; i-- {
+		k := take[i][n]
+		taken[accounts[i]] = k
+		n -= k
+	}
+
+	return taken
+}
+
+// addTips returns the sum of the tips, saturating at the largest value so
+// totals that don't fit still compare above every smaller total.
+func addTips(a uint64, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+
+	return sum
+}
@@ -0,0 +1,33 @@
+package selector
+
+import (
+	"math/bits"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// tipPerGasSelect returns transactions with the best tip paid for each unit
+// of gas while respecting the nonce for each account/transaction. This is the
+// best return for the miner for the amount of work the transactions cost.
+var tipPerGasSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+	return greedySelect(m, howMany, func(a, b database.BlockTx) bool {
+
+		// Compare a.Tip/a.GasUnits with b.Tip/b.GasUnits without losing
+		// precision to division, using the full 128 bit products so a
+		// large tip can't overflow.
+		aHi, aLo := bits.Mul64(a.Tip, gasUnits(b))
+		bHi, bLo := bits.Mul64(b.Tip, gasUnits(a))
+
+		return aHi > bHi || (aHi == bHi && aLo > bLo)
+	})
+}
+
+// gasUnits returns the gas units for the transaction, counting a transaction
+// without gas as one unit so it can't divide by zero.
+func gasUnits(tx database.BlockTx) uint64 {
+	if tx.GasUnits == 0 {
+		return 1
+	}
+
+	return tx.GasUnits
+}
@@ -0,0 +1,179 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Incremental represents an append-only merkle tree. Only the hashes on the
+// right edge of the tree change when a value is appended, so each append
+// costs one hash per level. The root is always the same as the root of a
+// Tree constructed with NewTree from the same values in the same order.
+type Incremental[T Hashable[T]] struct {
+	values       []T
+	levels       [][][]byte
+	options      []func(t *Tree[T])
+	hashStrategy func() hash.Hash
+	mode         Mode
+}
+
+// NewIncremental constructs an empty append-only tree. It accepts the same
+// options as NewTree.
+func NewIncremental[T Hashable[T]](options ...func(t *Tree[T])) *Incremental[T] {
+	t := Tree[T]{
+		hashStrategy: sha256.New,
+	}
+
+	for _, option := range options {
+		option(&t)
+	}
+
+	return &Incremental[T]{
+		options:      options,
+		hashStrategy: t.hashStrategy,
+		mode:         t.mode,
+	}
+}
+
+// Append adds the value as the last leaf of the tree and brings the root
+// up to date.
+func (inc *Incremental[T]) Append(value T) error {
+	h, err := value.Hash()
+	if err != nil {
+		return err
+	}
+
+	leaf, err := hashLeaf(inc.mode, inc.hashStrategy, h)
+	if err != nil {
+		return err
+	}
+
+	if len(inc.levels) == 0 {
+		inc.levels = [][][]byte{nil}
+	}
+	inc.values = append(inc.values, value)
+	inc.levels[0] = append(inc.levels[0], leaf)
+
+	// Recalculate the last node of every level up to the root. The default
+	// mode always hashes the leaf level, even for a single leaf.
+	for k := 0; len(inc.levels[k]) > 1 || (k == 0 && inc.mode == ModeDefault); k++ {
+		level := inc.levels[k]
+		j := len(level) - 1
+
+		var parent []byte
+		switch {
+		case j%2 == 1:
+			parent, err = hashInterior(inc.mode, inc.hashStrategy, level[j-1], level[j])
+		case inc.mode == ModeDefault:
+			parent, err = hashInterior(inc.mode, inc.hashStrategy, level[j], level[j])
+		default:
+			parent = level[j]
+		}
+		if err != nil {
+			return err
+		}
+
+		if k+1 == len(inc.levels) {
+			inc.levels = append(inc.levels, nil)
+		}
+
+		if p := j / 2; p == len(inc.levels[k+1]) {
+			inc.levels[k+1] = append(inc.levels[k+1], parent)
+		} else {
+			inc.levels[k+1][p] = parent
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of values in the tree.
+func (inc *Incremental[T]) Len() int {
+	return len(inc.values)
+}
+
+// Values returns a copy of the values in the order they were appended.
+func (inc *Incremental[T]) Values() []T {
+	values := make([]T, len(inc.values))
+	copy(values, inc.values)
+
+	return values
+}
+
+// Root returns the merkle root of the tree, which is nil for an empty tree.
+func (inc *Incremental[T]) Root() []byte {
+	if len(inc.levels) == 0 {
+		return nil
+	}
+
+	return inc.levels[len(inc.levels)-1][0]
+}
+
+// RootHex converts the merkle root byte hash to a hex encoded string.
+func (inc *Incremental[T]) RootHex() string {
+	return hexutil.Encode(inc.Root())
+}
+
+// Proof returns the set of hashes and the order of concatenating those
+// hashes for proving the data is in the tree, in the same form as
+// Tree.Proof.
+func (inc *Incremental[T]) Proof(data T) ([][]byte, []int64, error) {
+	index := -1
+	for i, value := range inc.values {
+		if value.Equals(data) {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, nil, errors.New("unable to find data in tree")
+	}
+
+	var merkleProof [][]byte
+	var order []int64
+
+	for _, level := range inc.levels[:len(inc.levels)-1] {
+		switch {
+		case index%2 == 1:
+			merkleProof = append(merkleProof, level[index-1])
+			order = append(order, 0) // left leaf, concat first.
+		case index+1 < len(level):
+			merkleProof = append(merkleProof, level[index+1])
+			order = append(order, 1) // right leaf, concat second.
+		case inc.mode == ModeDefault:
+			merkleProof = append(merkleProof, level[index])
+			order = append(order, 1) // paired with itself.
+		}
+		index /= 2
+	}
+
+	return merkleProof, order, nil
+}
+
+// ProofFor returns the serializable proof for the data, recording the mode of
+// the tree so the proof can be verified without it.
+func (inc *Incremental[T]) ProofFor(data T) (Proof, error) {
+	merkleProof, order, err := inc.Proof(data)
+	if err != nil {
+		return Proof{}, err
+	}
+
+	leafHash, err := data.Hash()
+	if err != nil {
+		return Proof{}, err
+	}
+
+	proof := NewProof(leafHash, merkleProof, order, inc.Root())
+	proof.Mode = inc.mode
+
+	return proof, nil
+}
+
+// Tree constructs the full tree for the values appended so far, using the
+// options the incremental tree was constructed with.
+func (inc *Incremental[T]) Tree() (*Tree[T], error) {
+	return NewTree(inc.Values(), inc.options...)
+}
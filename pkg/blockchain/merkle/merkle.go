@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"hash"
+	"sort"
+	"sync"
 )
 
 // Hashable represents the behavior concrete data must exhibit to be used in
@@ -18,6 +20,35 @@ type Hashable[T any] interface {
 
 // =============================================================================
 
+// Mode defines how the leaf and interior hashes of a tree are produced.
+type Mode uint8
+
+// Set of modes a tree can be constructed with.
+const (
+	// ModeDefault hashes the concatenation of the child hashes for interior
+	// nodes, uses the data hash as the leaf hash and duplicates the last node
+	// of a level with an odd count.
+	ModeDefault Mode = iota
+
+	// ModeDomainSeparated prefixes leaf hashes with 0x00 and interior hashes
+	// with 0x01 so a leaf can never be mistaken for an interior node, and
+	// promotes the last node of a level with an odd count to the next level
+	// instead of duplicating it.
+	ModeDomainSeparated
+)
+
+// concurrentMinWork is the smallest number of hashes in a level that is worth
+// spreading across workers when the tree is constructed concurrently.
+const concurrentMinWork = 64
+
+// Domain separation prefixes used by ModeDomainSeparated.
+const (
+	leafPrefix     byte = 0x00
+	interiorPrefix byte = 0x01
+)
+
+// =============================================================================
+
 // Tree represents a merkle tree that uses data of some type T that exhibits the
 // behavior define by the Hashable construct.
 type Tree[T Hashable[T]] struct {
@@ -25,6 +56,8 @@ type Tree[T Hashable[T]] struct {
 	Leafs        []*Node[T]
 	MerkleRoot   []byte
 	hashStrategy func() hash.Hash
+	mode         Mode
+	workers      int
 }
 
 // WithHashStrategy is used to change the default hash strategy of using sha256
@@ -35,6 +68,23 @@ func WithHashStrategy[T Hashable[T]](hashStrategy func() hash.Hash) func(t *Tree
 	}
 }
 
+// WithDomainSeparation is used to construct the tree in ModeDomainSeparated
+// rather than the default mode. The roots of the two modes are not the same.
+func WithDomainSeparation[T Hashable[T]]() func(t *Tree[T]) {
+	return func(t *Tree[T]) {
+		t.mode = ModeDomainSeparated
+	}
+}
+
+// WithConcurrency is used to hash the leafs and each level of the tree using
+// up to the specified number of goroutines when the tree is constructed. The
+// tree and its root are identical to the ones constructed serially.
+func WithConcurrency[T Hashable[T]](workers int) func(t *Tree[T]) {
+	return func(t *Tree[T]) {
+		t.workers = workers
+	}
+}
+
 // NewTree constructs a new merkle tree that uses data of some type T that
 // exhibits the behavior defined by the Hashable interface.
 func NewTree[T Hashable[T]](values []T, options ...func(t *Tree[T])) (*Tree[T], error) {
@@ -63,22 +113,27 @@ func (t *Tree[T]) Generate(values []T) error {
 		return errors.New("cannot construct tree with no content")
 	}
 
-	var leafs []*Node[T]
-	for _, value := range values {
-		h, err := value.Hash()
+	leafs := make([]*Node[T], len(values), len(values)+1)
+	err := t.forEach(len(values), func(i int) error {
+		h, err := t.leafHash(values[i])
 		if err != nil {
 			return err
 		}
 
-		leafs = append(leafs, &Node[T]{
+		leafs[i] = &Node[T]{
 			Hash:  h,
-			Value: value,
+			Value: values[i],
 			leaf:  true,
 			Tree:  t,
-		})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if len(leafs)%2 == 1 {
+	if len(leafs)%2 == 1 && t.mode == ModeDefault {
 		duplicate := &Node[T]{
 			Hash:  leafs[len(leafs)-1].Hash,
 			Value: leafs[len(leafs)-1].Value,
@@ -102,10 +157,14 @@ func (t *Tree[T]) Generate(values []T) error {
 }
 
 // Rebuild is a helper function that will rebuild the tree reusing only the
-// data that it currently holds in the leaves.
+// data that it currently holds in the leaves. The leaf duplicated to even
+// out the tree isn't data, so it's left for Generate to add again.
 func (t *Tree[T]) Rebuild() error {
 	var data []T
 	for _, node := range t.Leafs {
+		if node.dup {
+			continue
+		}
 		data = append(data, node.Value)
 	}
 
@@ -175,6 +234,101 @@ func (t *Tree[T]) Proof(data T) ([][]byte, []int64, error) {
 	return nil, nil, errors.New("unable to find data in tree")
 }
 
+// ProofFor returns the serializable proof for the data, recording the mode of
+// the tree so the proof can be verified without it.
+func (t *Tree[T]) ProofFor(data T) (Proof, error) {
+	merkleProof, order, err := t.Proof(data)
+	if err != nil {
+		return Proof{}, err
+	}
+
+	leafHash, err := data.Hash()
+	if err != nil {
+		return Proof{}, err
+	}
+
+	proof := NewProof(leafHash, merkleProof, order, t.MerkleRoot)
+	proof.Mode = t.mode
+
+	return proof, nil
+}
+
+// MultiProof returns the proof that all the specified values are in the
+// tree. Sibling hashes that can be calculated from the other values are left
+// out, so the proof is much smaller than a proof per value.
+func (t *Tree[T]) MultiProof(values []T) (MultiProof, error) {
+	levels := t.levels()
+
+	// Locate the leaf index of every value, ignoring values asked for twice.
+	indexes := make(map[int][]byte, len(values))
+	for _, value := range values {
+		index := -1
+		for i, node := range levels[0] {
+			if node.Value.Equals(value) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return MultiProof{}, errors.New("unable to find data in tree")
+		}
+
+		h, err := value.Hash()
+		if err != nil {
+			return MultiProof{}, err
+		}
+		indexes[index] = h
+	}
+
+	mp := MultiProof{
+		Mode:      t.mode,
+		LeafCount: len(levels[0]),
+		Root:      t.MerkleRoot,
+	}
+
+	known := make([]int, 0, len(indexes))
+	for index := range indexes {
+		known = append(known, index)
+	}
+	sort.Ints(known)
+
+	for _, index := range known {
+		mp.Indexes = append(mp.Indexes, index)
+		mp.LeafHashes = append(mp.LeafHashes, indexes[index])
+	}
+
+	// Walk up the tree adding the siblings that can't be calculated. This
+	// has to visit the nodes in the same order as MultiProof.Verify.
+	for _, level := range levels[:len(levels)-1] {
+		var next []int
+		for k := 0; k < len(known); k++ {
+			i := known[k]
+
+			switch {
+			case i%2 == 0 && i+1 < len(level):
+				if k+1 < len(known) && known[k+1] == i+1 {
+					k++
+					break
+				}
+				mp.Hashes = append(mp.Hashes, level[i+1].Hash)
+
+			case i%2 == 1:
+				mp.Hashes = append(mp.Hashes, level[i-1].Hash)
+			}
+
+			next = append(next, i/2)
+		}
+		known = next
+	}
+
+	return mp, nil
+}
+
+// Mode returns the mode the tree was constructed with.
+func (t *Tree[T]) Mode() Mode {
+	return t.mode
+}
+
 // Verify validates the hashes at each level of the tree and returns true
 // if the resulting hash at the root of the tree matches the resulting root hash.
 func (t *Tree[T]) Verify() error {
@@ -213,12 +367,12 @@ func (t *Tree[T]) VerifyData(data T) error {
 				return err
 			}
 
-			h := t.hashStrategy()
-			if _, err := h.Write(append(leftBytes, rightBytes...)); err != nil {
+			h, err := hashInterior(t.mode, t.hashStrategy, leftBytes, rightBytes)
+			if err != nil {
 				return err
 			}
 
-			if !bytes.Equal(h.Sum(nil), currentParent.Hash) {
+			if !bytes.Equal(h, currentParent.Hash) {
 				return errors.New("merkle root is not equivalent to the merkle root calculated on the critical path")
 			}
 
@@ -239,7 +393,7 @@ func (t *Tree[T]) Values() []T {
 	}
 
 	l := len(t.Leafs)
-	if bytes.Equal(t.Leafs[l-1].Hash, t.Leafs[l-2].Hash) {
+	if t.Leafs[l-1].dup {
 		return values[:l-1]
 	}
 
@@ -273,6 +427,190 @@ func (t *Tree[T]) MarshalText() (text []byte, err error) {
 
 // =============================================================================
 
+// Proof is the serializable form of the information returned by Tree.Proof.
+// It holds everything needed to verify the leaf is part of the tree with the
+// specified root without having access to the tree.
+type Proof struct {
+	Mode     Mode            `json:"mode"`
+	LeafHash hexutil.Bytes   `json:"leaf_hash"`
+	Hashes   []hexutil.Bytes `json:"hashes"`
+	Order    []int64         `json:"order"`
+	Root     hexutil.Bytes   `json:"root"`
+}
+
+// NewProof constructs a proof from the leaf hash, the proof and order
+// returned by Tree.Proof, and the root of a tree in the default mode.
+func NewProof(leafHash []byte, proof [][]byte, order []int64, root []byte) Proof {
+	hashes := make([]hexutil.Bytes, len(proof))
+	for i, h := range proof {
+		hashes[i] = h
+	}
+
+	return Proof{
+		LeafHash: leafHash,
+		Hashes:   hashes,
+		Order:    order,
+		Root:     root,
+	}
+}
+
+// Verify checks the proof in the mode it was recorded with using the
+// specified hash strategy, which must be the same strategy the tree was
+// constructed with.
+func (p Proof) Verify(hashStrategy func() hash.Hash) error {
+	proof := make([][]byte, len(p.Hashes))
+	for i, h := range p.Hashes {
+		proof[i] = h
+	}
+
+	return VerifyProofMode(p.Mode, p.LeafHash, proof, p.Order, p.Root, hashStrategy)
+}
+
+// VerifyProof validates the proof and order returned by Tree.Proof for the
+// leaf hash produce the specified root, without access to the tree. An order
+// of 0 means the proof hash is concatenated first and an order of 1 means it
+// is concatenated second. The tree must have been constructed in the
+// default mode.
+func VerifyProof(leafHash []byte, proof [][]byte, order []int64, root []byte, hashStrategy func() hash.Hash) error {
+	return VerifyProofMode(ModeDefault, leafHash, proof, order, root, hashStrategy)
+}
+
+// VerifyProofMode works like VerifyProof for a tree constructed in the
+// specified mode. The leaf hash is always the hash of the data.
+func VerifyProofMode(mode Mode, leafHash []byte, proof [][]byte, order []int64, root []byte, hashStrategy func() hash.Hash) error {
+	if len(proof) != len(order) {
+		return fmt.Errorf("proof and order lengths don't match, got %d and %d", len(proof), len(order))
+	}
+
+	hash, err := hashLeaf(mode, hashStrategy, leafHash)
+	if err != nil {
+		return err
+	}
+
+	for i := range proof {
+		switch order[i] {
+		case 0:
+			hash, err = hashInterior(mode, hashStrategy, proof[i], hash)
+		case 1:
+			hash, err = hashInterior(mode, hashStrategy, hash, proof[i])
+		default:
+			return fmt.Errorf("invalid proof order %d at %d", order[i], i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !bytes.Equal(hash, root) {
+		return errors.New("proof does not produce the merkle root")
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// MultiProof is the serializable proof that a set of leaves are all in the
+// tree with the specified root. The leaf hashes are the hashes of the data
+// at the matching leaf indexes, in ascending index order.
+type MultiProof struct {
+	Mode       Mode            `json:"mode"`
+	LeafCount  int             `json:"leaf_count"`
+	Indexes    []int           `json:"indexes"`
+	LeafHashes []hexutil.Bytes `json:"leaf_hashes"`
+	Hashes     []hexutil.Bytes `json:"hashes"`
+	Root       hexutil.Bytes   `json:"root"`
+}
+
+// Verify checks the multiproof produces the root using the specified hash
+// strategy, which must be the same strategy the tree was constructed with.
+func (p MultiProof) Verify(hashStrategy func() hash.Hash) error {
+	if len(p.Indexes) == 0 || len(p.Indexes) != len(p.LeafHashes) {
+		return fmt.Errorf("indexes and leaf hashes don't match, got %d and %d", len(p.Indexes), len(p.LeafHashes))
+	}
+
+	type node struct {
+		index int
+		hash  []byte
+	}
+
+	known := make([]node, len(p.Indexes))
+	for i, index := range p.Indexes {
+		if index < 0 || index >= p.LeafCount || (i > 0 && index <= p.Indexes[i-1]) {
+			return fmt.Errorf("invalid leaf index %d at %d", index, i)
+		}
+
+		h, err := hashLeaf(p.Mode, hashStrategy, p.LeafHashes[i])
+		if err != nil {
+			return err
+		}
+		known[i] = node{index: index, hash: h}
+	}
+
+	var used int
+	sibling := func() ([]byte, error) {
+		if used == len(p.Hashes) {
+			return nil, errors.New("not enough proof hashes")
+		}
+		used++
+		return p.Hashes[used-1], nil
+	}
+
+	// The default mode always hashes the leaf level, even for a single leaf,
+	// since the last leaf of an odd count is paired with itself.
+	for size, depth := p.LeafCount, 0; size > 1 || (depth == 0 && p.Mode == ModeDefault); size, depth = (size+1)/2, depth+1 {
+		var next []node
+		for k := 0; k < len(known); k++ {
+			i, h := known[k].index, known[k].hash
+
+			var parent []byte
+			var err error
+			switch {
+			case i%2 == 0 && i+1 < size:
+				var right []byte
+				if k+1 < len(known) && known[k+1].index == i+1 {
+					right = known[k+1].hash
+					k++
+				} else if right, err = sibling(); err != nil {
+					return err
+				}
+				parent, err = hashInterior(p.Mode, hashStrategy, h, right)
+
+			case i%2 == 1:
+				var left []byte
+				if left, err = sibling(); err != nil {
+					return err
+				}
+				parent, err = hashInterior(p.Mode, hashStrategy, left, h)
+
+			case p.Mode == ModeDefault:
+				parent, err = hashInterior(p.Mode, hashStrategy, h, h)
+
+			default:
+				parent = h
+			}
+			if err != nil {
+				return err
+			}
+
+			next = append(next, node{index: i / 2, hash: parent})
+		}
+		known = next
+	}
+
+	if used != len(p.Hashes) {
+		return fmt.Errorf("unused proof hashes, used %d of %d", used, len(p.Hashes))
+	}
+
+	if !bytes.Equal(known[0].hash, p.Root) {
+		return errors.New("multiproof does not produce the merkle root")
+	}
+
+	return nil
+}
+
+// =============================================================================
+
 // Node represents a node, root, or leaf in the tree. It stores pointers to its
 // immediate relationships, a hash, the data if it is a leaf, and other metadata.
 type Node[T Hashable[T]] struct {
@@ -290,7 +628,7 @@ type Node[T Hashable[T]] struct {
 // each level and returning the resulting hash of the node.
 func (n *Node[T]) verify() ([]byte, error) {
 	if n.leaf {
-		return n.Value.Hash()
+		return n.Tree.leafHash(n.Value)
 	}
 
 	rightBytes, err := n.Right.verify()
@@ -303,26 +641,16 @@ func (n *Node[T]) verify() ([]byte, error) {
 		return nil, err
 	}
 
-	h := n.Tree.hashStrategy()
-	if _, err := h.Write(append(leftBytes, rightBytes...)); err != nil {
-		return nil, err
-	}
-
-	return h.Sum(nil), nil
+	return hashInterior(n.Tree.mode, n.Tree.hashStrategy, leftBytes, rightBytes)
 }
 
 // CalculateHash is a helper function that calculates the hash of the node.
 func (n *Node[T]) CalculateHash() ([]byte, error) {
 	if n.leaf {
-		return n.Value.Hash()
-	}
-
-	h := n.Tree.hashStrategy()
-	if _, err := h.Write(append(n.Left.Hash, n.Right.Hash...)); err != nil {
-		return nil, err
+		return n.Tree.leafHash(n.Value)
 	}
 
-	return h.Sum(nil), nil
+	return hashInterior(n.Tree.mode, n.Tree.hashStrategy, n.Left.Hash, n.Right.Hash)
 }
 
 // String returns a string representation of the node.
@@ -336,35 +664,159 @@ func (n *Node[T]) String() string {
 // constructs the intermediate and root levels of the tree. Returns the resulting
 // root node of the tree.
 func buildIntermediate[T Hashable[T]](nl []*Node[T], t *Tree[T]) (*Node[T], error) {
+	// The last remaining node is the root of the tree.
+	if len(nl) == 1 {
+		return nl[0], nil
+	}
+
 	var nodes []*Node[T]
+	var promoted *Node[T]
 
 	for i := 0; i < len(nl); i += 2 {
 		left, right := i, i+1
 		if i+1 == len(nl) {
+			if t.mode == ModeDomainSeparated {
+				promoted = nl[i]
+				continue
+			}
 			right = i
 		}
 
-		h := t.hashStrategy()
-		chash := append(nl[left].Hash, nl[right].Hash...)
-		if _, err := h.Write(chash); err != nil {
-			return nil, err
-		}
-
 		n := Node[T]{
 			Left:  nl[left],
 			Right: nl[right],
-			Hash:  h.Sum(nil),
 			Tree:  t,
 		}
 
 		nodes = append(nodes, &n)
 		nl[left].Parent = &n
 		nl[right].Parent = &n
+	}
 
-		if len(nl) == 2 {
-			return &n, nil
+	err := t.forEach(len(nodes), func(i int) error {
+		h, err := hashInterior(t.mode, t.hashStrategy, nodes[i].Left.Hash, nodes[i].Right.Hash)
+		if err != nil {
+			return err
 		}
+		nodes[i].Hash = h
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if promoted != nil {
+		nodes = append(nodes, promoted)
 	}
 
 	return buildIntermediate(nodes, t)
 }
+
+// forEach calls the function for every index up to n. When the tree is
+// constructed concurrently and there is enough work, the indexes are split
+// into ranges that are processed by separate goroutines.
+func (t *Tree[T]) forEach(n int, fn func(i int) error) error {
+	workers := t.workers
+	if workers > n/concurrentMinWork {
+		workers = n / concurrentMinWork
+	}
+
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	size := (n + workers - 1) / workers
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			end := min((w+1)*size, n)
+			for i := w * size; i < end; i++ {
+				if err := fn(i); err != nil {
+					errs[w] = err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// levels returns the nodes of the tree level by level, starting with the leafs
+// without any duplicate and ending with the root.
+func (t *Tree[T]) levels() [][]*Node[T] {
+	var level []*Node[T]
+	for _, node := range t.Leafs {
+		if !node.dup {
+			level = append(level, node)
+		}
+	}
+
+	levels := [][]*Node[T]{level}
+	for len(level) > 1 || (len(levels) == 1 && t.mode == ModeDefault) {
+		var next []*Node[T]
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) && t.mode == ModeDomainSeparated {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, level[i].Parent)
+		}
+		level = next
+		levels = append(levels, level)
+	}
+
+	return levels
+}
+
+// leafHash returns the hash of the leaf node holding the value.
+func (t *Tree[T]) leafHash(value T) ([]byte, error) {
+	h, err := value.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	return hashLeaf(t.mode, t.hashStrategy, h)
+}
+
+// hashLeaf produces the leaf node hash for the hash of the data.
+func hashLeaf(mode Mode, hashStrategy func() hash.Hash, dataHash []byte) ([]byte, error) {
+	if mode != ModeDomainSeparated {
+		return dataHash, nil
+	}
+
+	return sum(hashStrategy, []byte{leafPrefix}, dataHash)
+}
+
+// hashInterior produces the interior node hash for the two child hashes.
+func hashInterior(mode Mode, hashStrategy func() hash.Hash, left []byte, right []byte) ([]byte, error) {
+	if mode != ModeDomainSeparated {
+		return sum(hashStrategy, left, right)
+	}
+
+	return sum(hashStrategy, []byte{interiorPrefix}, left, right)
+}
+
+// sum hashes the concatenation of the specified parts.
+func sum(hashStrategy func() hash.Hash, parts ...[]byte) ([]byte, error) {
+	h := hashStrategy()
+	for _, part := range parts {
+		if _, err := h.Write(part); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.Sum(nil), nil
+}
@@ -3,8 +3,12 @@ package merkle_test
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"github.com/sphierex/blockchain/pkg/blockchain/merkle"
 	"hash"
+	"runtime"
+	"strconv"
 	"testing"
 )
 
@@ -79,6 +83,17 @@ func Test_RebuildTree(t *testing.T) {
 		if !bytes.Equal(tree.MerkleRoot, table[i].expectedHash) {
 			t.Errorf("[case:%d] error: expected hash equal to %v got %v", table[i].testCaseID, table[i].expectedHash, tree.MerkleRoot)
 		}
+
+		// The leaf duplicated for an odd number of leaves isn't a value.
+		values := tree.Values()
+		if len(values) != len(table[i].data) {
+			t.Fatalf("[case:%d] error: expected %d values got %d", table[i].testCaseID, len(table[i].data), len(values))
+		}
+		for j, value := range values {
+			if !value.Equals(table[i].data[j]) {
+				t.Errorf("[case:%d] error: expected value %v at %d got %v", table[i].testCaseID, table[i].data[j], j, value)
+			}
+		}
 	}
 }
 
@@ -199,6 +214,461 @@ func Test_MerklePath(t *testing.T) {
 	}
 }
 
+func Test_VerifyProof(t *testing.T) {
+	for i := 0; i < len(table); i++ {
+		tree, err := merkle.NewTree(table[i].data, merkle.WithHashStrategy[Data](table[i].hashStrategy))
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+		}
+
+		for j := 0; j < len(table[i].data); j++ {
+			merkleProof, order, err := tree.Proof(table[i].data[j])
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			leafHash, err := table[i].data[j].Hash()
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			if err := merkle.VerifyProof(leafHash, merkleProof, order, tree.MerkleRoot, table[i].hashStrategy); err != nil {
+				t.Errorf("[case:%d] error: expected valid proof for %d: %v", table[i].testCaseID, j, err)
+			}
+
+			// The proof must survive a round trip through JSON.
+			data, err := json.Marshal(merkle.NewProof(leafHash, merkleProof, order, tree.MerkleRoot))
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			var proof merkle.Proof
+			if err := json.Unmarshal(data, &proof); err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+			if err := proof.Verify(table[i].hashStrategy); err != nil {
+				t.Errorf("[case:%d] error: expected valid decoded proof for %d: %v", table[i].testCaseID, j, err)
+			}
+
+			// A proof for other data or with a different order must fail.
+			otherHash, err := table[i].notInContents.Hash()
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+			if err := merkle.VerifyProof(otherHash, merkleProof, order, tree.MerkleRoot, table[i].hashStrategy); err == nil {
+				t.Errorf("[case:%d] error: expected invalid proof for data not in the tree", table[i].testCaseID)
+			}
+
+			badOrder := append([]int64{}, order...)
+			badOrder[0] ^= 1
+			if err := merkle.VerifyProof(leafHash, merkleProof, badOrder, tree.MerkleRoot, table[i].hashStrategy); err == nil && !bytes.Equal(merkleProof[0], leafHash) {
+				t.Errorf("[case:%d] error: expected invalid proof for a different order", table[i].testCaseID)
+			}
+
+			if err := merkle.VerifyProof(leafHash, merkleProof, order[1:], tree.MerkleRoot, table[i].hashStrategy); err == nil {
+				t.Errorf("[case:%d] error: expected invalid proof for mismatched lengths", table[i].testCaseID)
+			}
+		}
+	}
+}
+
+func Test_DomainSeparation(t *testing.T) {
+	for i := 0; i < len(table); i++ {
+		tree, err := merkle.NewTree(table[i].data, merkle.WithHashStrategy[Data](table[i].hashStrategy), merkle.WithDomainSeparation[Data]())
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+		}
+		if tree.Mode() != merkle.ModeDomainSeparated {
+			t.Errorf("[case:%d] error: expected mode %d, got %d", table[i].testCaseID, merkle.ModeDomainSeparated, tree.Mode())
+		}
+		if bytes.Equal(tree.MerkleRoot, table[i].expectedHash) {
+			t.Errorf("[case:%d] error: expected a root different from the default mode", table[i].testCaseID)
+		}
+		if err := tree.Verify(); err != nil {
+			t.Errorf("[case:%d] error: expected tree to be valid: %v", table[i].testCaseID, err)
+		}
+		if n := len(tree.Values()); n != len(table[i].data) {
+			t.Errorf("[case:%d] error: expected %d values, got %d", table[i].testCaseID, len(table[i].data), n)
+		}
+
+		for j := 0; j < len(table[i].data); j++ {
+			if err := tree.VerifyData(table[i].data[j]); err != nil {
+				t.Errorf("[case:%d] error: expected valid content for %d: %v", table[i].testCaseID, j, err)
+			}
+
+			proof, err := tree.ProofFor(table[i].data[j])
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			data, err := json.Marshal(proof)
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			var decoded merkle.Proof
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+			if err := decoded.Verify(table[i].hashStrategy); err != nil {
+				t.Errorf("[case:%d] error: expected valid proof for %d: %v", table[i].testCaseID, j, err)
+			}
+
+			// The same proof checked in the default mode must fail.
+			decoded.Mode = merkle.ModeDefault
+			if err := decoded.Verify(table[i].hashStrategy); err == nil {
+				t.Errorf("[case:%d] error: expected proof to be invalid in the default mode", table[i].testCaseID)
+			}
+		}
+	}
+}
+
+func Test_DomainSeparationOddLeafs(t *testing.T) {
+	odd := []Data{{x: "Hello"}, {x: "Hi"}, {x: "Hey"}}
+	dup := []Data{{x: "Hello"}, {x: "Hi"}, {x: "Hey"}, {x: "Hey"}}
+
+	// In the default mode duplicating the last leaf produces the same root.
+	oddTree, err := merkle.NewTree(odd)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	dupTree, err := merkle.NewTree(dup)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !bytes.Equal(oddTree.MerkleRoot, dupTree.MerkleRoot) {
+		t.Fatalf("error: expected the default mode roots to match")
+	}
+
+	oddTree, err = merkle.NewTree(odd, merkle.WithDomainSeparation[Data]())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	dupTree, err = merkle.NewTree(dup, merkle.WithDomainSeparation[Data]())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if bytes.Equal(oddTree.MerkleRoot, dupTree.MerkleRoot) {
+		t.Errorf("error: expected the domain separated roots to be different")
+	}
+
+	// A single leaf is its own root.
+	single, err := merkle.NewTree(odd[:1], merkle.WithDomainSeparation[Data]())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	proof, err := single.ProofFor(odd[0])
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(proof.Hashes) != 0 {
+		t.Errorf("error: expected an empty proof, got %d hashes", len(proof.Hashes))
+	}
+	if err := proof.Verify(sha256.New); err != nil {
+		t.Errorf("error: expected valid proof: %v", err)
+	}
+}
+
+func Test_MultiProof(t *testing.T) {
+	modes := []func(t *merkle.Tree[Data]){
+		func(t *merkle.Tree[Data]) {},
+		merkle.WithDomainSeparation[Data](),
+	}
+
+	for i := 0; i < len(table); i++ {
+		for _, mode := range modes {
+			tree, err := merkle.NewTree(table[i].data, merkle.WithHashStrategy[Data](table[i].hashStrategy), mode)
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+
+			// Prove every combination of the values in the tree.
+			for set := 1; set < 1<<len(table[i].data); set++ {
+				var values []Data
+				var single int
+				for j := range table[i].data {
+					if set&(1<<j) == 0 {
+						continue
+					}
+					values = append(values, table[i].data[j])
+
+					merkleProof, _, err := tree.Proof(table[i].data[j])
+					if err != nil {
+						t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+					}
+					single += len(merkleProof)
+				}
+
+				mp, err := tree.MultiProof(values)
+				if err != nil {
+					t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+				}
+				if len(mp.Hashes) > single {
+					t.Errorf("[case:%d] error: expected no more than %d hashes for set %b, got %d", table[i].testCaseID, single, set, len(mp.Hashes))
+				}
+
+				data, err := json.Marshal(mp)
+				if err != nil {
+					t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+				}
+
+				var decoded merkle.MultiProof
+				if err := json.Unmarshal(data, &decoded); err != nil {
+					t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+				}
+				if err := decoded.Verify(table[i].hashStrategy); err != nil {
+					t.Errorf("[case:%d] error: expected valid multiproof for set %b: %v", table[i].testCaseID, set, err)
+				}
+
+				// Changing any of the leafs must break the proof.
+				decoded.LeafHashes[0], err = table[i].notInContents.Hash()
+				if err != nil {
+					t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+				}
+				if err := decoded.Verify(table[i].hashStrategy); err == nil {
+					t.Errorf("[case:%d] error: expected invalid multiproof for set %b", table[i].testCaseID, set)
+				}
+			}
+
+			if _, err := tree.MultiProof([]Data{table[i].notInContents}); err == nil {
+				t.Errorf("[case:%d] error: expected an error for data not in the tree", table[i].testCaseID)
+			}
+		}
+	}
+}
+
+func Test_Incremental(t *testing.T) {
+	modes := []func(t *merkle.Tree[Data]){
+		func(t *merkle.Tree[Data]) {},
+		merkle.WithDomainSeparation[Data](),
+	}
+
+	for i := 0; i < len(table); i++ {
+		for _, mode := range modes {
+			inc := merkle.NewIncremental(merkle.WithHashStrategy[Data](table[i].hashStrategy), mode)
+			if inc.Root() != nil {
+				t.Errorf("[case:%d] error: expected no root for an empty tree", table[i].testCaseID)
+			}
+
+			for j, value := range table[i].data {
+				if err := inc.Append(value); err != nil {
+					t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+				}
+
+				// The root must match a tree built from scratch every time.
+				tree, err := merkle.NewTree(table[i].data[:j+1], merkle.WithHashStrategy[Data](table[i].hashStrategy), mode)
+				if err != nil {
+					t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+				}
+				if !bytes.Equal(inc.Root(), tree.MerkleRoot) {
+					t.Errorf("[case:%d] error: expected root %x after %d values, got %x", table[i].testCaseID, tree.MerkleRoot, j+1, inc.Root())
+				}
+
+				for _, proven := range table[i].data[:j+1] {
+					expProof, expOrder, err := tree.Proof(proven)
+					if err != nil {
+						t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+					}
+
+					gotProof, gotOrder, err := inc.Proof(proven)
+					if err != nil {
+						t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+					}
+					if len(gotProof) != len(expProof) || len(gotOrder) != len(expOrder) {
+						t.Fatalf("[case:%d] error: expected proof of %d hashes, got %d", table[i].testCaseID, len(expProof), len(gotProof))
+					}
+					for k := range expProof {
+						if !bytes.Equal(gotProof[k], expProof[k]) || gotOrder[k] != expOrder[k] {
+							t.Errorf("[case:%d] error: expected the same proof as the tree at %d", table[i].testCaseID, k)
+						}
+					}
+
+					proof, err := inc.ProofFor(proven)
+					if err != nil {
+						t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+					}
+					if err := proof.Verify(table[i].hashStrategy); err != nil {
+						t.Errorf("[case:%d] error: expected valid proof: %v", table[i].testCaseID, err)
+					}
+				}
+			}
+
+			if inc.Len() != len(table[i].data) {
+				t.Errorf("[case:%d] error: expected %d values, got %d", table[i].testCaseID, len(table[i].data), inc.Len())
+			}
+
+			tree, err := inc.Tree()
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseID, err)
+			}
+			if !bytes.Equal(tree.MerkleRoot, inc.Root()) {
+				t.Errorf("[case:%d] error: expected the tree root to match", table[i].testCaseID)
+			}
+
+			if _, _, err := inc.Proof(table[i].notInContents); err == nil {
+				t.Errorf("[case:%d] error: expected an error for data not in the tree", table[i].testCaseID)
+			}
+		}
+	}
+}
+
+func Test_Concurrency(t *testing.T) {
+	modes := []func(t *merkle.Tree[Data]){
+		func(t *merkle.Tree[Data]) {},
+		merkle.WithDomainSeparation[Data](),
+	}
+
+	for _, size := range []int{1, 2, 3, 63, 64, 65, 129, 1000, 4097} {
+		data := make([]Data, size)
+		for i := range data {
+			data[i] = Data{x: strconv.Itoa(i)}
+		}
+
+		for _, mode := range modes {
+			serial, err := merkle.NewTree(data, mode)
+			if err != nil {
+				t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+			}
+
+			concurrent, err := merkle.NewTree(data, mode, merkle.WithConcurrency[Data](8))
+			if err != nil {
+				t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+			}
+
+			if !bytes.Equal(serial.MerkleRoot, concurrent.MerkleRoot) {
+				t.Errorf("[size:%d] error: expected root %x, got %x", size, serial.MerkleRoot, concurrent.MerkleRoot)
+			}
+			if err := concurrent.Verify(); err != nil {
+				t.Errorf("[size:%d] error: expected tree to be valid: %v", size, err)
+			}
+			if n := len(concurrent.Values()); n != size {
+				t.Errorf("[size:%d] error: expected %d values, got %d", size, size, n)
+			}
+
+			for _, i := range []int{0, size / 2, size - 1} {
+				proof, err := concurrent.ProofFor(data[i])
+				if err != nil {
+					t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+				}
+				if err := proof.Verify(sha256.New); err != nil {
+					t.Errorf("[size:%d] error: expected valid proof for %d: %v", size, i, err)
+				}
+			}
+		}
+	}
+}
+
+func Benchmark_NewTree(b *testing.B) {
+	for _, size := range []int{1_000, 10_000} {
+		data := make([]Data, size)
+		for i := range data {
+			data[i] = Data{x: strconv.Itoa(i)}
+		}
+
+		b.Run(fmt.Sprintf("serial/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := merkle.NewTree(data); err != nil {
+					b.Fatalf("error: unexpected error: %v", err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("concurrent/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := merkle.NewTree(data, merkle.WithConcurrency[Data](runtime.NumCPU())); err != nil {
+					b.Fatalf("error: unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_Sparse(t *testing.T) {
+	key := func(i int) []byte {
+		h := sha256.Sum256([]byte(strconv.Itoa(i)))
+		return h[:20]
+	}
+	value := func(i int) []byte {
+		h := sha256.Sum256([]byte("value" + strconv.Itoa(i)))
+		return h[:]
+	}
+
+	for _, size := range []int{0, 1, 2, 3, 50} {
+		tree := merkle.NewSparse(20)
+		for i := 0; i < size; i++ {
+			if err := tree.Set(key(i), value(i)); err != nil {
+				t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+			}
+		}
+
+		// The order keys are added in doesn't change the root.
+		reversed := merkle.NewSparse(20)
+		for i := size - 1; i >= 0; i-- {
+			if err := reversed.Set(key(i), value(i)); err != nil {
+				t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+			}
+		}
+		if !bytes.Equal(tree.Root(), reversed.Root()) {
+			t.Errorf("[size:%d] error: expected the same root regardless of order", size)
+		}
+
+		for i := 0; i < size+10; i++ {
+			proof, err := tree.Proof(key(i))
+			if err != nil {
+				t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+			}
+
+			data, err := json.Marshal(proof)
+			if err != nil {
+				t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+			}
+
+			var decoded merkle.SparseProof
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("[size:%d] error: unexpected error: %v", size, err)
+			}
+			if err := decoded.Verify(); err != nil {
+				t.Errorf("[size:%d] error: expected valid proof for key %d: %v", size, i, err)
+			}
+			if included := i < size; decoded.Included() != included {
+				t.Errorf("[size:%d] error: expected key %d included to be %t", size, i, included)
+			}
+
+			// Claiming a different value or the opposite result must fail.
+			if decoded.Included() {
+				decoded.ValueHash = value(i + 1)
+				if err := decoded.Verify(); err == nil {
+					t.Errorf("[size:%d] error: expected invalid proof for the wrong value of key %d", size, i)
+				}
+				continue
+			}
+
+			if size > 0 {
+				decoded.ValueHash = value(i)
+				if err := decoded.Verify(); err == nil {
+					t.Errorf("[size:%d] error: expected invalid inclusion proof for key %d", size, i)
+				}
+			}
+		}
+
+		// Removing a key puts the root back to what it was without it.
+		if size > 0 {
+			before := merkle.NewSparse(20)
+			for i := 0; i < size-1; i++ {
+				_ = before.Set(key(i), value(i))
+			}
+			tree.Delete(key(size - 1))
+			if !bytes.Equal(tree.Root(), before.Root()) {
+				t.Errorf("[size:%d] error: expected the root without the deleted key", size)
+			}
+		}
+	}
+
+	if err := merkle.NewSparse(20).Set([]byte{1}, value(0)); err == nil {
+		t.Errorf("error: expected an error for the wrong key size")
+	}
+}
+
 // =============================================================================
 
 func calHash(hash []byte, hashStrategy func() hash.Hash) ([]byte, error) {
@@ -0,0 +1,235 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Sparse represents a sparse merkle tree of fixed size keys, where the bits
+// of a key are the path from the root to its leaf. A subtree without keys
+// hashes to the empty hash and a subtree with a single key is collapsed into
+// the leaf for that key, so the tree only costs hashing for the levels where
+// keys share a prefix. Leaf hashes are sha256(0x00|key|valueHash) and
+// interior hashes are sha256(0x01|left|right).
+type Sparse struct {
+	keySize int
+	leafs   map[string][]byte
+}
+
+// NewSparse constructs an empty sparse merkle tree for keys of the specified
+// number of bytes.
+func NewSparse(keySize int) *Sparse {
+	return &Sparse{
+		keySize: keySize,
+		leafs:   make(map[string][]byte),
+	}
+}
+
+// Set adds the key to the tree or replaces the value hash of the key.
+func (s *Sparse) Set(key []byte, valueHash []byte) error {
+	if len(key) != s.keySize {
+		return fmt.Errorf("invalid key size, got %d, exp %d", len(key), s.keySize)
+	}
+
+	s.leafs[string(key)] = valueHash
+
+	return nil
+}
+
+// Delete removes the key from the tree.
+func (s *Sparse) Delete(key []byte) {
+	delete(s.leafs, string(key))
+}
+
+// Get returns the value hash of the key and whether the key is in the tree.
+func (s *Sparse) Get(key []byte) ([]byte, bool) {
+	valueHash, exists := s.leafs[string(key)]
+	return valueHash, exists
+}
+
+// Len returns the number of keys in the tree.
+func (s *Sparse) Len() int {
+	return len(s.leafs)
+}
+
+// Root returns the merkle root of the tree.
+func (s *Sparse) Root() []byte {
+	return s.hash(s.keys(), 0)
+}
+
+// RootHex converts the merkle root byte hash to a hex encoded string.
+func (s *Sparse) RootHex() string {
+	return hexutil.Encode(s.Root())
+}
+
+// Proof returns the proof that the key is or is not in the tree. The
+// proof holds the siblings on the path of the key from the root down to
+// where the path ends in the key's leaf, an empty subtree or the leaf of
+// another key.
+func (s *Sparse) Proof(key []byte) (SparseProof, error) {
+	if len(key) != s.keySize {
+		return SparseProof{}, fmt.Errorf("invalid key size, got %d, exp %d", len(key), s.keySize)
+	}
+
+	keys := s.keys()
+	proof := SparseProof{
+		Key:  key,
+		Root: s.hash(keys, 0),
+	}
+
+	for depth := 0; len(keys) > 1; depth++ {
+		left, right := split(keys, depth)
+		if bit(key, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, s.hash(right, depth+1))
+			keys = left
+			continue
+		}
+		proof.Siblings = append(proof.Siblings, s.hash(left, depth+1))
+		keys = right
+	}
+
+	if len(keys) == 1 {
+		switch leafKey := keys[0]; {
+		case bytes.Equal(leafKey, key):
+			proof.ValueHash = s.leafs[string(leafKey)]
+		default:
+			proof.LeafKey = leafKey
+			proof.LeafValueHash = s.leafs[string(leafKey)]
+		}
+	}
+
+	return proof, nil
+}
+
+// keys returns the keys in the tree in sorted order, which is also the order
+// of their leafs from left to right.
+func (s *Sparse) keys() [][]byte {
+	keys := make([][]byte, 0, len(s.leafs))
+	for key := range s.leafs {
+		keys = append(keys, []byte(key))
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	return keys
+}
+
+// hash calculates the hash of the subtree holding the sorted keys, which all
+// share the same path down to the specified depth.
+func (s *Sparse) hash(keys [][]byte, depth int) []byte {
+	switch len(keys) {
+	case 0:
+		return emptyHash()
+	case 1:
+		return sparseLeaf(keys[0], s.leafs[string(keys[0])])
+	}
+
+	left, right := split(keys, depth)
+
+	return sparseInterior(s.hash(left, depth+1), s.hash(right, depth+1))
+}
+
+// =============================================================================
+
+// SparseProof is the serializable proof that a key is or is not in a sparse
+// merkle tree. The value hash is only set when the key is in the tree. When
+// the path of the key ends in the leaf of another key, that key and its value
+// hash are provided instead. The siblings are ordered from the root down.
+type SparseProof struct {
+	Key           hexutil.Bytes   `json:"key"`
+	ValueHash     hexutil.Bytes   `json:"value_hash,omitempty"`
+	LeafKey       hexutil.Bytes   `json:"leaf_key,omitempty"`
+	LeafValueHash hexutil.Bytes   `json:"leaf_value_hash,omitempty"`
+	Siblings      []hexutil.Bytes `json:"siblings"`
+	Root          hexutil.Bytes   `json:"root"`
+}
+
+// Included returns true when the proof is an inclusion proof.
+func (p SparseProof) Included() bool {
+	return p.ValueHash != nil
+}
+
+// Verify checks the proof produces the root. A valid inclusion proof proves
+// the key has the value hash, otherwise the proof proves the key is not in
+// the tree.
+func (p SparseProof) Verify() error {
+	if len(p.Siblings) > len(p.Key)*8 {
+		return fmt.Errorf("too many siblings, got %d, max %d", len(p.Siblings), len(p.Key)*8)
+	}
+
+	var hash []byte
+	switch {
+	case p.Included():
+		hash = sparseLeaf(p.Key, p.ValueHash)
+
+	case p.LeafKey != nil:
+		if len(p.LeafKey) != len(p.Key) || bytes.Equal(p.LeafKey, p.Key) {
+			return errors.New("invalid leaf key for a non-inclusion proof")
+		}
+		for depth := range p.Siblings {
+			if bit(p.LeafKey, depth) != bit(p.Key, depth) {
+				return errors.New("leaf key is not on the path of the key")
+			}
+		}
+		hash = sparseLeaf(p.LeafKey, p.LeafValueHash)
+
+	default:
+		hash = emptyHash()
+	}
+
+	for depth := len(p.Siblings) - 1; depth >= 0; depth-- {
+		if bit(p.Key, depth) == 0 {
+			hash = sparseInterior(hash, p.Siblings[depth])
+			continue
+		}
+		hash = sparseInterior(p.Siblings[depth], hash)
+	}
+
+	if !bytes.Equal(hash, p.Root) {
+		return errors.New("proof does not produce the sparse merkle root")
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// split divides the sorted keys into the keys with a 0 and the keys with a 1
+// at the bit for the specified depth.
+func split(keys [][]byte, depth int) ([][]byte, [][]byte) {
+	i := sort.Search(len(keys), func(i int) bool {
+		return bit(keys[i], depth) == 1
+	})
+
+	return keys[:i], keys[i:]
+}
+
+// bit returns the bit of the key at the specified depth, starting with the
+// most significant bit of the first byte.
+func bit(key []byte, depth int) byte {
+	return (key[depth/8] >> (7 - depth%8)) & 1
+}
+
+// emptyHash returns the hash of a subtree without keys.
+func emptyHash() []byte {
+	return make([]byte, sha256.Size)
+}
+
+// sparseLeaf returns the hash of the leaf for the key.
+func sparseLeaf(key []byte, valueHash []byte) []byte {
+	h, _ := sum(sha256.New, []byte{leafPrefix}, key, valueHash)
+	return h
+}
+
+// sparseInterior returns the hash of an interior node.
+func sparseInterior(left []byte, right []byte) []byte {
+	h, _ := sum(sha256.New, []byte{interiorPrefix}, left, right)
+	return h
+}
@@ -0,0 +1,153 @@
+// Package peer maintains the peer related information such as the set
+// of known peers and their status.
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// HostHeader is the HTTP header a node sets on its requests to other nodes
+// so they know the private host to reach it on.
+const HostHeader = "X-Node-Host"
+
+// Peer represents information about a Node in the network.
+type Peer struct {
+	Host string `json:"host"`
+}
+
+// New constructs a new info value.
+func New(host string) Peer {
+	return Peer{
+		Host: host,
+	}
+}
+
+// Match validates if the specified host matches this node.
+func (p Peer) Match(host string) bool {
+	return p.Host == host
+}
+
+// =============================================================================
+
+// PeerStatus represents information about the status of any given peer.
+type PeerStatus struct {
+	LatestBlockHash   string `json:"latest_block_hash"`
+	LatestBlockNumber uint64 `json:"latest_block_number"`
+	KnownPeers        []Peer `json:"known_peers"`
+}
+
+// =============================================================================
+
+// ErrTooManyPeers is returned when a peer can't be added because the set
+// holds the maximum number of peers.
+var ErrTooManyPeers = errors.New("too many known peers")
+
+// defaultMaxPeers is the number of peers a set holds when no maximum is
+// provided.
+const defaultMaxPeers = 100
+
+// PeerSet represents the data representation to maintain a set of known
+// peers, along with the number of times in a row each peer has failed to
+// respond.
+type PeerSet struct {
+	mu       sync.RWMutex
+	maxPeers int
+	set      map[Peer]int
+}
+
+// WithMaxPeers is used to change the maximum number of peers the set holds.
+func WithMaxPeers(maxPeers int) func(ps *PeerSet) {
+	return func(ps *PeerSet) {
+		ps.maxPeers = maxPeers
+	}
+}
+
+// NewPeerSet constructs a new info set to manage node peer information.
+func NewPeerSet(options ...func(ps *PeerSet)) *PeerSet {
+	ps := PeerSet{
+		maxPeers: defaultMaxPeers,
+		set:      make(map[Peer]int),
+	}
+
+	for _, option := range options {
+		option(&ps)
+	}
+
+	return &ps
+}
+
+// Add adds a new node to the set. It returns false when the peer was
+// already in the set, and ErrTooManyPeers when the set is full.
+func (ps *PeerSet) Add(peer Peer) (bool, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, exists := ps.set[peer]; exists {
+		return false, nil
+	}
+
+	if len(ps.set) >= ps.maxPeers {
+		return false, fmt.Errorf("%w, max %d", ErrTooManyPeers, ps.maxPeers)
+	}
+
+	ps.set[peer] = 0
+	return true, nil
+}
+
+// Failed records the peer failed to respond and returns the number of
+// times in a row it has failed. Zero is returned for an unknown peer.
+func (ps *PeerSet) Failed(peer Peer) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	failures, exists := ps.set[peer]
+	if !exists {
+		return 0
+	}
+
+	failures++
+	ps.set[peer] = failures
+
+	return failures
+}
+
+// Responded records the peer responded, which clears its failures.
+func (ps *PeerSet) Responded(peer Peer) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, exists := ps.set[peer]; exists {
+		ps.set[peer] = 0
+	}
+}
+
+// Remove removes a node from the set.
+func (ps *PeerSet) Remove(peer Peer) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.set, peer)
+}
+
+// Copy returns a list of the known peers, sorted by host, leaving out the
+// peer matching the specified host.
+func (ps *PeerSet) Copy(host string) []Peer {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	peers := make([]Peer, 0, len(ps.set))
+	for peer := range ps.set {
+		if !peer.Match(host) {
+			peers = append(peers, peer)
+		}
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Host < peers[j].Host
+	})
+
+	return peers
+}
@@ -0,0 +1,83 @@
+package peer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/peer"
+)
+
+func Test_PeerSet(t *testing.T) {
+	ps := peer.NewPeerSet()
+
+	table := []struct {
+		testCaseID int
+		host       string
+		added      bool
+	}{
+		{testCaseID: 1, host: "0.0.0.0:9180", added: true},
+		{testCaseID: 2, host: "0.0.0.0:9080", added: true},
+		{testCaseID: 3, host: "0.0.0.0:9180", added: false},
+		{testCaseID: 4, host: "0.0.0.0:9280", added: true},
+	}
+
+	for _, tt := range table {
+		added, err := ps.Add(peer.New(tt.host))
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+		if added != tt.added {
+			t.Errorf("[case:%d] error: expected added to be %t, got %t", tt.testCaseID, tt.added, added)
+		}
+	}
+
+	// The copy is sorted and leaves out the specified host.
+	peers := ps.Copy("0.0.0.0:9180")
+	exp := []string{"0.0.0.0:9080", "0.0.0.0:9280"}
+	if len(peers) != len(exp) {
+		t.Fatalf("error: expected %d peers, got %d", len(exp), len(peers))
+	}
+	for i, host := range exp {
+		if !peers[i].Match(host) {
+			t.Errorf("error: expected peer %d to be %s, got %s", i, host, peers[i].Host)
+		}
+	}
+
+	ps.Remove(peer.New("0.0.0.0:9080"))
+	if peers := ps.Copy(""); len(peers) != 2 {
+		t.Errorf("error: expected 2 peers after remove, got %d", len(peers))
+	}
+}
+
+func Test_PeerSetLimits(t *testing.T) {
+	ps := peer.NewPeerSet(peer.WithMaxPeers(2))
+
+	for _, host := range []string{"0.0.0.0:9080", "0.0.0.0:9180"} {
+		if _, err := ps.Add(peer.New(host)); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	// A known peer can still be added again once the set is full.
+	if added, err := ps.Add(peer.New("0.0.0.0:9080")); added || err != nil {
+		t.Errorf("error: expected the known peer to be skipped, got %t %v", added, err)
+	}
+	if _, err := ps.Add(peer.New("0.0.0.0:9280")); !errors.Is(err, peer.ErrTooManyPeers) {
+		t.Errorf("error: expected ErrTooManyPeers, got %v", err)
+	}
+
+	// Failures are counted in a row until the peer responds.
+	pr := peer.New("0.0.0.0:9080")
+	for exp := 1; exp <= 2; exp++ {
+		if failures := ps.Failed(pr); failures != exp {
+			t.Errorf("error: expected %d failures, got %d", exp, failures)
+		}
+	}
+	ps.Responded(pr)
+	if failures := ps.Failed(pr); failures != 1 {
+		t.Errorf("error: expected the failures to be reset, got %d", failures)
+	}
+	if failures := ps.Failed(peer.New("0.0.0.0:9380")); failures != 0 {
+		t.Errorf("error: expected no failures for an unknown peer, got %d", failures)
+	}
+}
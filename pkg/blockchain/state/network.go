@@ -0,0 +1,423 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/peer"
+)
+
+// baseURL represents the base URL for the private node api.
+const baseURL = "http://%s/v1/node"
+
+// syncBatchSize is the number of blocks requested from a peer at a time
+// while syncing.
+const syncBatchSize = 100
+
+// client is used for all the requests made to other nodes.
+var client = http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// NetRequestPeerStatus asks the peer for its latest block and the list of
+// peers it knows about.
+func (s *State) NetRequestPeerStatus(pr peer.Peer) (peer.PeerStatus, error) {
+	s.log.Infow("state", "status", "NetRequestPeerStatus: started", "peer", pr.Host)
+	defer s.log.Infow("state", "status", "NetRequestPeerStatus: completed", "peer", pr.Host)
+
+	url := fmt.Sprintf("%s/status", fmt.Sprintf(baseURL, pr.Host))
+
+	var ps peer.PeerStatus
+	if err := s.send(http.MethodGet, url, nil, &ps); err != nil {
+		s.peerFailed(pr)
+		return peer.PeerStatus{}, err
+	}
+	s.knownPeers.Responded(pr)
+
+	s.log.Infow("state", "status", "NetRequestPeerStatus: peer-node-state", "peer", pr.Host, "latest_block", ps.LatestBlockNumber, "hash", ps.LatestBlockHash)
+
+	return ps, nil
+}
+
+// NetRequestPeerStatuses asks all the known peers for their status at the
+// same time, so an unreachable peer only holds things up for as long as the
+// request timeout. The statuses of the peers that responded are returned.
+func (s *State) NetRequestPeerStatuses() map[peer.Peer]peer.PeerStatus {
+	peers := s.KnownExternalPeers()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[peer.Peer]peer.PeerStatus, len(peers))
+
+	wg.Add(len(peers))
+	for _, pr := range peers {
+		go func(pr peer.Peer) {
+			defer wg.Done()
+
+			peerStatus, err := s.NetRequestPeerStatus(pr)
+			if err != nil {
+				s.log.Infow("state", "status", "NetRequestPeerStatuses: ERROR", "peer", pr.Host, "ERROR", err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[pr] = peerStatus
+		}(pr)
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+// NetRequestPeerBlocks asks the peer for the blocks in the specified
+// inclusive range, which the peer cuts short at its latest block.
+func (s *State) NetRequestPeerBlocks(pr peer.Peer, from uint64, to uint64) ([]database.BlockData, error) {
+	s.log.Infow("state", "status", "NetRequestPeerBlocks: started", "peer", pr.Host, "from", from, "to", to)
+	defer s.log.Infow("state", "status", "NetRequestPeerBlocks: completed", "peer", pr.Host, "from", from, "to", to)
+
+	url := fmt.Sprintf("%s/block/list/%d/%d", fmt.Sprintf(baseURL, pr.Host), from, to)
+
+	var blocks []database.BlockData
+	if err := s.send(http.MethodGet, url, nil, &blocks); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// Sync catches the blockchain up with the known peer holding the highest
+// block. The node reports that it's synced once this returns, even when the
+// sync fails, so the node can carry on with the blocks it has.
+func (s *State) Sync() error {
+	defer s.synced.Store(true)
+
+	// Find the peer with the highest block.
+	var best peer.Peer
+	var bestStatus peer.PeerStatus
+	for pr, peerStatus := range s.NetRequestPeerStatuses() {
+		if peerStatus.LatestBlockNumber > bestStatus.LatestBlockNumber {
+			best = pr
+			bestStatus = peerStatus
+		}
+	}
+
+	if latest := s.LatestBlock().Header.Number; bestStatus.LatestBlockNumber <= latest {
+		s.log.Infow("state", "status", "Sync: up to date", "latest_block", latest)
+		return nil
+	}
+
+	return s.syncWith(best, bestStatus.LatestBlockNumber)
+}
+
+// SyncWith catches the blockchain up with the specified peer when the peer
+// has a higher block. It's used when the peer proposes a block that doesn't
+// build on the latest block.
+func (s *State) SyncWith(pr peer.Peer) error {
+	peerStatus, err := s.NetRequestPeerStatus(pr)
+	if err != nil {
+		return fmt.Errorf("peer %s: %w", pr.Host, err)
+	}
+
+	if latest := s.LatestBlock().Header.Number; peerStatus.LatestBlockNumber <= latest {
+		s.log.Infow("state", "status", "SyncWith: up to date", "peer", pr.Host, "latest_block", latest)
+		return nil
+	}
+
+	return s.syncWith(pr, peerStatus.LatestBlockNumber)
+}
+
+// syncWith downloads the missing blocks from the peer in batches up to the
+// specified block, and each block is validated and applied the same way as
+// a block proposed by a peer. When the peer's chain has forked from this
+// one, the chain holding the most work wins. Mining is held back while this
+// runs.
+func (s *State) syncWith(pr peer.Peer, target uint64) error {
+	s.log.Infow("state", "status", "syncWith: started", "peer", pr.Host, "latest_block", s.LatestBlock().Header.Number, "target", target)
+	defer func() {
+		s.syncing.Store(false)
+		s.log.Infow("state", "status", "syncWith: completed", "peer", pr.Host, "latest_block", s.LatestBlock().Header.Number)
+	}()
+
+	s.syncing.Store(true)
+	s.Worker.SignalCancelMining()
+
+	for latest := s.LatestBlock().Header.Number; latest < target; {
+		from := latest + 1
+		to := min(from+syncBatchSize-1, target)
+
+		blocks, err := s.NetRequestPeerBlocks(pr, from, to)
+		if err != nil {
+			return fmt.Errorf("peer %s, blocks %d-%d: %w", pr.Host, from, to, err)
+		}
+
+		if len(blocks) == 0 {
+			return fmt.Errorf("peer %s, blocks %d-%d: no blocks returned", pr.Host, from, to)
+		}
+
+		for _, blockData := range blocks {
+			// A block proposed by a peer while syncing may already have
+			// been applied.
+			if blockData.Header.Number <= s.LatestBlock().Header.Number {
+				continue
+			}
+
+			block, err := database.ToBlock(blockData)
+			if err != nil {
+				return fmt.Errorf("peer %s, block %d: %w", pr.Host, blockData.Header.Number, err)
+			}
+
+			err = s.processBlock(block)
+			switch {
+			case errors.Is(err, database.ErrChainForked):
+				s.log.Infow("state", "status", "syncWith: chain forked", "peer", pr.Host, "block", block.Header.Number)
+				return s.reorgWith(pr, target)
+
+			case err != nil:
+				return fmt.Errorf("peer %s: %w", pr.Host, err)
+			}
+		}
+
+		// Stop when the peer keeps sending blocks that don't move the
+		// chain forward.
+		next := s.LatestBlock().Header.Number
+		if next == latest {
+			return fmt.Errorf("peer %s, blocks %d-%d: no new blocks returned", pr.Host, from, to)
+		}
+		latest = next
+	}
+
+	return nil
+}
+
+// reorgWith switches to the peer's chain after it has forked from this one.
+// The latest block both chains share is found first, then the peer's blocks
+// after it are downloaded and applied to a fork of the database, which
+// validates them in full. The local blocks are only replaced when every
+// block is valid and the peer's chain holds more work. The mempool is then
+// rebuilt against the new accounts, including the transactions from the
+// local blocks that were dropped.
+func (s *State) reorgWith(pr peer.Peer, target uint64) error {
+	ancestor, err := s.findAncestor(pr)
+	if err != nil {
+		return fmt.Errorf("peer %s, common ancestor: %w", pr.Host, err)
+	}
+
+	s.log.Infow("state", "status", "reorgWith: common ancestor", "peer", pr.Host, "block", ancestor)
+
+	fork, err := s.db.Fork(ancestor)
+	if err != nil {
+		return err
+	}
+
+	for latest := ancestor; latest < target; {
+		from := latest + 1
+		to := min(from+syncBatchSize-1, target)
+
+		blocks, err := s.NetRequestPeerBlocks(pr, from, to)
+		if err != nil {
+			return fmt.Errorf("peer %s, blocks %d-%d: %w", pr.Host, from, to, err)
+		}
+
+		for _, blockData := range blocks {
+			block, err := database.ToBlock(blockData)
+			if err != nil {
+				return fmt.Errorf("peer %s, block %d: %w", pr.Host, blockData.Header.Number, err)
+			}
+
+			if err := fork.ApplyBlock(block); err != nil {
+				return fmt.Errorf("peer %s: %w", pr.Host, err)
+			}
+		}
+
+		// The peer may have less than it claimed, so compare the work of
+		// the blocks it did send.
+		next := fork.LatestBlock().Header.Number
+		if next == latest {
+			break
+		}
+		latest = next
+	}
+
+	dropped, err := s.db.Reorg(fork)
+	if err != nil {
+		return fmt.Errorf("peer %s: %w", pr.Host, err)
+	}
+
+	s.log.Infow("state", "status", "reorgWith: switched chains", "peer", pr.Host, "ancestor", ancestor, "dropped", len(dropped), "latest_block", s.LatestBlock().Header.Number)
+
+	var trans []database.BlockTx
+	for _, blockData := range dropped {
+		trans = append(trans, blockData.Trans...)
+	}
+	s.mempool.Rebuild(trans, s.accountNonce)
+
+	// Anything being mined was built on the old chain.
+	s.Worker.SignalCancelMining()
+	if s.MempoolHasReady() {
+		s.Worker.SignalStartMining()
+	}
+
+	return nil
+}
+
+// findAncestor returns the number of the latest block this node shares with
+// the peer, walking back from the latest block a batch at a time. Block zero
+// is the genesis, which every node on the chain shares.
+func (s *State) findAncestor(pr peer.Peer) (uint64, error) {
+	for to := s.LatestBlock().Header.Number; to > 0; {
+		from := uint64(1)
+		if to > syncBatchSize {
+			from = to - syncBatchSize + 1
+		}
+
+		blocks, err := s.NetRequestPeerBlocks(pr, from, to)
+		if err != nil {
+			return 0, fmt.Errorf("blocks %d-%d: %w", from, to, err)
+		}
+
+		for i := len(blocks) - 1; i >= 0; i-- {
+			num := blocks[i].Header.Number
+			if num < from || num > to {
+				continue
+			}
+
+			block, err := database.ToBlock(blocks[i])
+			if err != nil {
+				return 0, fmt.Errorf("block %d: %w", num, err)
+			}
+
+			local, err := s.db.GetBlock(num)
+			if err != nil {
+				continue
+			}
+
+			if local.Hash == block.Hash() {
+				return num, nil
+			}
+		}
+
+		to = from - 1
+	}
+
+	return 0, nil
+}
+
+// NetSendNodeAvailableToPeers shares this node is available to participate
+// in the network with all the known peers.
+func (s *State) NetSendNodeAvailableToPeers() {
+	s.log.Infow("state", "status", "NetSendNodeAvailableToPeers: started")
+	defer s.log.Infow("state", "status", "NetSendNodeAvailableToPeers: completed")
+
+	host := peer.New(s.host)
+
+	for _, pr := range s.KnownExternalPeers() {
+		url := fmt.Sprintf("%s/peers", fmt.Sprintf(baseURL, pr.Host))
+
+		if err := s.send(http.MethodPost, url, host, nil); err != nil {
+			s.log.Infow("state", "status", "NetSendNodeAvailableToPeers: ERROR", "peer", pr.Host, "ERROR", err)
+		}
+	}
+}
+
+// NetSendBlockToPeers takes the new mined block and sends it to all the
+// known peers.
+func (s *State) NetSendBlockToPeers(block database.Block) {
+	s.log.Infow("state", "status", "NetSendBlockToPeers: started", "block", block.Header.Number)
+	defer s.log.Infow("state", "status", "NetSendBlockToPeers: completed", "block", block.Header.Number)
+
+	blockData := database.ToBlockData(block)
+
+	for _, pr := range s.KnownExternalPeers() {
+		url := fmt.Sprintf("%s/block/propose", fmt.Sprintf(baseURL, pr.Host))
+
+		if err := s.send(http.MethodPost, url, blockData, nil); err != nil {
+			s.log.Infow("state", "status", "NetSendBlockToPeers: ERROR", "peer", pr.Host, "ERROR", err)
+		}
+	}
+}
+
+// NetSendTxsToPeers shares a batch of newly accepted transactions with all
+// the known peers.
+func (s *State) NetSendTxsToPeers(trans []database.BlockTx) {
+	s.log.Infow("state", "status", "NetSendTxsToPeers: started", "trans", len(trans))
+	defer s.log.Infow("state", "status", "NetSendTxsToPeers: completed", "trans", len(trans))
+
+	for _, pr := range s.KnownExternalPeers() {
+		url := fmt.Sprintf("%s/tx/submit", fmt.Sprintf(baseURL, pr.Host))
+
+		if err := s.send(http.MethodPost, url, trans, nil); err != nil {
+			s.log.Infow("state", "status", "NetSendTxsToPeers: ERROR", "peer", pr.Host, "ERROR", err)
+		}
+	}
+}
+
+// =============================================================================
+
+// peerFailed records the peer failed to respond, and removes the peer from
+// the known peer list once it has failed too many times in a row.
+func (s *State) peerFailed(pr peer.Peer) {
+	if failures := s.knownPeers.Failed(pr); failures >= maxPeerFailures {
+		s.log.Infow("state", "status", "removing peer", "peer", pr.Host, "failures", failures)
+		s.RemoveKnownPeer(pr)
+	}
+}
+
+// send is a helper function to send an HTTP request to a node. The request
+// carries the host of this node so the peer can reach it.
+func (s *State) send(method string, url string, dataSend any, dataRecv any) error {
+	var req *http.Request
+
+	switch {
+	case dataSend != nil:
+		data, err := json.Marshal(dataSend)
+		if err != nil {
+			return err
+		}
+		req, err = http.NewRequest(method, url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+	default:
+		var err error
+		req, err = http.NewRequest(method, url, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.host != "" {
+		req.Header.Set(peer.HostHeader, s.host)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if dataRecv != nil {
+		if err := json.NewDecoder(resp.Body).Decode(dataRecv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
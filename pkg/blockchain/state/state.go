@@ -0,0 +1,573 @@
+// Package state is the core API for the blockchain and implements all the
+// business rules and processing.
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sphierex/blockchain/internal/web/metrics"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/genesis"
+	"github.com/sphierex/blockchain/pkg/blockchain/mempool"
+	"github.com/sphierex/blockchain/pkg/blockchain/merkle"
+	"github.com/sphierex/blockchain/pkg/blockchain/peer"
+	"go.uber.org/zap"
+)
+
+// ErrNoTransactions is returned when a block is requested to be mined
+// and there are no transactions.
+var ErrNoTransactions = errors.New("no transactions to mine")
+
+// maxSeenTxs is the number of transaction hashes remembered so transactions
+// shared between nodes are only accepted and shared once.
+const maxSeenTxs = 10_000
+
+// maxPeerFailures is the number of times in a row a peer can fail to
+// respond before it's removed from the known peer list.
+const maxPeerFailures = 3
+
+// maxTxTimeDrift is how far a peer's transaction timestamp can be from the
+// local clock before the transaction is rejected.
+const maxTxTimeDrift = 30 * time.Second
+
+// =============================================================================
+
+// Worker interface represents the behavior required to be implemented by any
+// package providing support for mining.
+type Worker interface {
+	Shutdown()
+	SignalStartMining()
+	SignalCancelMining()
+	SignalShareTx(tx database.BlockTx)
+	SignalSync(pr peer.Peer)
+}
+
+// noWorker is used until a worker is registered with the state.
+type noWorker struct{}
+
+func (noWorker) Shutdown()                      {}
+func (noWorker) SignalStartMining()             {}
+func (noWorker) SignalCancelMining()            {}
+func (noWorker) SignalShareTx(database.BlockTx) {}
+func (noWorker) SignalSync(peer.Peer)           {}
+
+// =============================================================================
+
+// Config represents the configuration required to start
+// the blockchain node. When MiningWorkers is zero, one mining
+// worker is used per CPU, and the mempool uses its defaults
+// for any limit or TTL left at zero. Host is the private host
+// other nodes use to reach this node.
+type Config struct {
+	BeneficiaryID    database.AccountID
+	Host             string
+	KnownPeers       *peer.PeerSet
+	Genesis          genesis.Genesis
+	Storage          database.Storage
+	SelectStrategy   string
+	ReplaceBump      uint64
+	MaxFuturePerAcct int
+	MaxMempool       int
+	MaxPerAcct       int
+	MempoolTTL       time.Duration
+	MiningWorkers    int
+	Log              *zap.SugaredLogger
+}
+
+// State manages the blockchain database.
+type State struct {
+	Worker Worker
+
+	beneficiaryID database.AccountID
+	host          string
+	knownPeers    *peer.PeerSet
+	miningWorkers int
+	log           *zap.SugaredLogger
+	genesis       genesis.Genesis
+	mempool       *mempool.Mempool
+	seen          *seenTxs
+	synced        atomic.Bool
+	syncing       atomic.Bool
+	db            *database.Database
+}
+
+// New constructs a new blockchain for data management.
+func New(cfg Config) (*State, error) {
+	if !cfg.BeneficiaryID.IsAccountID() {
+		return nil, errors.New("invalid beneficiary account")
+	}
+
+	// Construct a mempool with the specified sort strategy and limits.
+	options := []func(mp *mempool.Mempool){
+		mempool.WithLogger(cfg.Log),
+	}
+	if cfg.SelectStrategy != "" {
+		options = append(options, mempool.WithStrategy(cfg.SelectStrategy))
+	}
+	if cfg.ReplaceBump > 0 {
+		options = append(options, mempool.WithReplaceBump(cfg.ReplaceBump))
+	}
+	if cfg.MaxFuturePerAcct > 0 {
+		options = append(options, mempool.WithMaxFuture(cfg.MaxFuturePerAcct))
+	}
+	if cfg.MaxMempool > 0 {
+		options = append(options, mempool.WithMaxCount(cfg.MaxMempool))
+	}
+	if cfg.MaxPerAcct > 0 {
+		options = append(options, mempool.WithMaxPerAccount(cfg.MaxPerAcct))
+	}
+	if cfg.MempoolTTL > 0 {
+		options = append(options, mempool.WithTTL(cfg.MempoolTTL))
+	}
+
+	mp, err := mempool.New(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Access the storage for the blockchain and replay it on top of
+	// the genesis balances.
+	db, err := database.New(cfg.Genesis, cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	miningWorkers := cfg.MiningWorkers
+	if miningWorkers <= 0 {
+		miningWorkers = runtime.NumCPU()
+	}
+
+	knownPeers := cfg.KnownPeers
+	if knownPeers == nil {
+		knownPeers = peer.NewPeerSet()
+	}
+
+	state := State{
+		Worker:        noWorker{},
+		beneficiaryID: cfg.BeneficiaryID,
+		host:          cfg.Host,
+		knownPeers:    knownPeers,
+		miningWorkers: miningWorkers,
+		log:           cfg.Log,
+		genesis:       cfg.Genesis,
+		mempool:       mp,
+		seen:          newSeenTxs(maxSeenTxs),
+		db:            db,
+	}
+
+	// A node with peers has to catch up with them before it's ready, which
+	// happens once Sync is called.
+	state.synced.Store(len(state.KnownExternalPeers()) == 0)
+
+	return &state, nil
+}
+
+// Shutdown cleanly brings the node down.
+func (s *State) Shutdown() error {
+	s.log.Infow("state", "status", "shutdown started")
+	defer s.log.Infow("state", "status", "shutdown completed")
+
+	// Make sure the worker stops mining before the storage is closed.
+	s.Worker.Shutdown()
+
+	return s.db.Close()
+}
+
+// Genesis returns a copy of the genesis information.
+func (s *State) Genesis() genesis.Genesis {
+	return s.genesis
+}
+
+// Host returns the private host of this node.
+func (s *State) Host() string {
+	return s.host
+}
+
+// KnownExternalPeers retrieves a copy of the known peer list without
+// including this node.
+func (s *State) KnownExternalPeers() []peer.Peer {
+	return s.knownPeers.Copy(s.host)
+}
+
+// AddKnownPeer provides the ability to add a new peer to the known peer
+// list. It returns false when the peer is this node or is already known,
+// and an error when the list is full.
+func (s *State) AddKnownPeer(pr peer.Peer) (bool, error) {
+	if pr.Match(s.host) {
+		return false, nil
+	}
+
+	return s.knownPeers.Add(pr)
+}
+
+// RemoveKnownPeer provides the ability to remove a peer from the known
+// peer list.
+func (s *State) RemoveKnownPeer(pr peer.Peer) {
+	s.knownPeers.Remove(pr)
+}
+
+// IsSynced returns true once the node has caught up with its peers at
+// startup. Syncing with a peer after that doesn't change it.
+func (s *State) IsSynced() bool {
+	return s.synced.Load()
+}
+
+// IsSyncing returns true until the node has caught up with its peers at
+// startup, and while blocks are being downloaded from a peer.
+func (s *State) IsSyncing() bool {
+	return !s.synced.Load() || s.syncing.Load()
+}
+
+// Status returns the latest block and known peers of this node.
+func (s *State) Status() peer.PeerStatus {
+	latest := s.db.LatestBlock()
+
+	return peer.PeerStatus{
+		LatestBlockHash:   latest.Hash(),
+		LatestBlockNumber: latest.Header.Number,
+		KnownPeers:        s.KnownExternalPeers(),
+	}
+}
+
+// LatestBlock returns a copy the current latest block.
+func (s *State) LatestBlock() database.Block {
+	return s.db.LatestBlock()
+}
+
+// HashState returns the state root of the current accounts.
+func (s *State) HashState() string {
+	return s.db.HashState()
+}
+
+// QueryBlock returns the block with the specified number from storage.
+func (s *State) QueryBlock(num uint64) (database.BlockData, error) {
+	return s.db.GetBlock(num)
+}
+
+// QueryBlockByHash returns the block with the specified hash from storage.
+func (s *State) QueryBlockByHash(hash string) (database.BlockData, error) {
+	return s.db.GetBlockByHash(hash)
+}
+
+// QueryBlocks returns the blocks in the specified inclusive range from
+// storage, stopping at the latest block.
+func (s *State) QueryBlocks(from uint64, to uint64) ([]database.BlockData, error) {
+	return s.db.GetBlocks(from, to)
+}
+
+// QueryTxProof returns the merkle proof that the transaction with the
+// specified hash is part of the specified block.
+func (s *State) QueryTxProof(num uint64, txHash string) (database.TxProof, error) {
+	blockData, err := s.db.GetBlock(num)
+	if err != nil {
+		return database.TxProof{}, err
+	}
+
+	block, err := database.ToBlock(blockData)
+	if err != nil {
+		return database.TxProof{}, err
+	}
+
+	return block.ProveTx(txHash)
+}
+
+// Accounts returns a copy of the accounts along with the latest block and
+// state root they were read at.
+func (s *State) Accounts() database.Snapshot {
+	return s.db.Snapshot()
+}
+
+// ProveAccount returns the proof that the account is or is not part of the
+// current state, along with the snapshot the proof was made from.
+func (s *State) ProveAccount(accountID database.AccountID) (database.Snapshot, merkle.SparseProof, error) {
+	snapshot := s.db.Snapshot()
+
+	proof, err := snapshot.Prove(accountID)
+	if err != nil {
+		return database.Snapshot{}, merkle.SparseProof{}, err
+	}
+
+	return snapshot, proof, nil
+}
+
+// Mempool returns a copy of the mempool in the order it would be mined.
+func (s *State) Mempool() []database.BlockTx {
+	return s.mempool.PickBest(0)
+}
+
+// MempoolHasReady returns true when the mempool holds a transaction that is
+// ready to be mined.
+func (s *State) MempoolHasReady() bool {
+	return s.mempool.HasReady()
+}
+
+// MempoolLength returns the current length of the mempool.
+func (s *State) MempoolLength() int {
+	return s.mempool.Count()
+}
+
+// UpsertWalletTransaction accepts a transaction from a wallet for inclusion.
+// The transaction must be signed for this chain and the sender must be able
+// to pay for it, given the nonce and balance recorded for the account. An
+// accepted transaction is shared with the known peers.
+func (s *State) UpsertWalletTransaction(signedTx database.SignedTx) (database.BlockTx, error) {
+	if err := signedTx.Validate(); err != nil {
+		return database.BlockTx{}, fmt.Errorf("invalid transaction: %w", err)
+	}
+
+	tx := database.NewBlockTx(signedTx, s.genesis.GasPrice, database.OneUnitOfGas)
+	if err := s.upsertTransaction(tx); err != nil {
+		return database.BlockTx{}, err
+	}
+
+	return tx, nil
+}
+
+// UpsertNodeTransaction accepts a transaction shared by a peer. It's checked
+// the same way as a wallet transaction, and a transaction that has already
+// been accepted by this node is rejected so it isn't shared again. A peer's
+// timestamp too far from the local clock is rejected so it can't expire the
+// transaction early or keep it in the mempool past the TTL. The timestamp is
+// part of the transaction hash, so it's never rewritten.
+func (s *State) UpsertNodeTransaction(tx database.BlockTx) error {
+	if err := tx.Validate(); err != nil {
+		return fmt.Errorf("invalid transaction: %w", err)
+	}
+
+	if tx.GasPrice != s.genesis.GasPrice || tx.GasUnits != database.OneUnitOfGas {
+		return fmt.Errorf("invalid gas, got %d x %d, exp %d x %d", tx.GasPrice, tx.GasUnits, s.genesis.GasPrice, database.OneUnitOfGas)
+	}
+
+	now := time.Now()
+	minTimeStamp := uint64(now.Add(-maxTxTimeDrift).Unix())
+	maxTimeStamp := uint64(now.Add(maxTxTimeDrift).Unix())
+	if tx.TimeStamp < minTimeStamp || tx.TimeStamp > maxTimeStamp {
+		return fmt.Errorf("invalid timestamp, got %d, exp %d to %d", tx.TimeStamp, minTimeStamp, maxTimeStamp)
+	}
+
+	return s.upsertTransaction(tx)
+}
+
+// =============================================================================
+
+// MineNewBlock attempts to create a new block with the best transactions
+// from the mempool. The search for a nonce can be cancelled with the context.
+// Once a block is found it is validated, written to storage and applied to
+// the accounts, and the mined transactions are removed from the mempool.
+func (s *State) MineNewBlock(ctx context.Context) (database.Block, error) {
+	trans := s.mempool.PickBest(s.genesis.TransPerBlock)
+
+	header, trans, err := s.db.NewCandidate(s.beneficiaryID, trans)
+	if err != nil {
+		return database.Block{}, fmt.Errorf("new candidate: %w", err)
+	}
+
+	if len(trans) == 0 {
+		return database.Block{}, ErrNoTransactions
+	}
+
+	s.log.Infow("mining", "status", "started", "block", header.Number, "trans", len(trans), "difficulty", header.Difficulty, "workers", s.miningWorkers)
+
+	block, stats, err := database.POW(ctx, database.POWArgs{
+		Header:  header,
+		Trans:   trans,
+		Workers: s.miningWorkers,
+		Report: func(worker int, stats database.POWStats) {
+			metrics.SetMiningHashRate(worker, stats.HashRate())
+		},
+	})
+	if err != nil {
+		s.log.Infow("mining", "status", "stopped", "block", header.Number, "attempts", stats.Attempts, "hash_rate", stats.HashRate(), "ERROR", err)
+		return database.Block{}, err
+	}
+
+	s.log.Infow("mining", "status", "solved", "block", header.Number, "hash", block.Hash(), "attempts", stats.Attempts, "hash_rate", stats.HashRate(), "duration", stats.Duration)
+
+	if err := s.db.ApplyBlock(block); err != nil {
+		return database.Block{}, fmt.Errorf("apply block: %w", err)
+	}
+
+	s.updateMempool(block)
+
+	return block, nil
+}
+
+// ProcessProposedBlock takes a block mined by the specified peer, validates
+// it against the latest block and applies it. When the block is ahead of the
+// latest block or the chains have forked, the block can't be applied and the
+// worker is signaled to sync with the peer instead.
+func (s *State) ProcessProposedBlock(pr peer.Peer, block database.Block) error {
+	s.log.Infow("state", "status", "ProcessProposedBlock: started", "peer", pr.Host, "block", block.Header.Number, "hash", block.Hash())
+	defer s.log.Infow("state", "status", "ProcessProposedBlock: completed", "peer", pr.Host, "block", block.Header.Number, "hash", block.Hash())
+
+	err := s.processBlock(block)
+	if err != nil && pr.Host != "" && (errors.Is(err, database.ErrBlockAhead) || errors.Is(err, database.ErrChainForked)) {
+		s.log.Infow("state", "status", "ProcessProposedBlock: signal sync", "peer", pr.Host, "ERROR", err)
+		s.Worker.SignalSync(pr)
+	}
+
+	return err
+}
+
+// =============================================================================
+
+// processBlock applies a block mined by a peer. Any mining in progress for
+// the same height is cancelled, the block's transactions are removed from
+// the mempool, and mining starts again if transactions are left over.
+func (s *State) processBlock(block database.Block) error {
+	if err := s.db.ApplyBlock(block); err != nil {
+		return fmt.Errorf("apply block: %w", err)
+	}
+
+	// The block being mined is now stale since it's at the height of the
+	// block that was just applied.
+	s.Worker.SignalCancelMining()
+
+	s.updateMempool(block)
+
+	if s.MempoolHasReady() {
+		s.Worker.SignalStartMining()
+	}
+
+	return nil
+}
+
+// upsertTransaction adds the transaction to the mempool when the sender can
+// pay for it, then shares it with the known peers and signals mining. The
+// hash is remembered so the same transaction is only ever accepted once.
+func (s *State) upsertTransaction(tx database.BlockTx) error {
+	if tx.ChainID != s.genesis.ChainID {
+		return fmt.Errorf("invalid chain id, got %d, exp %d", tx.ChainID, s.genesis.ChainID)
+	}
+
+	from, err := tx.FromAccount()
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	hash, err := tx.Hash()
+	if err != nil {
+		return err
+	}
+
+	if s.seen.contains(string(hash)) {
+		return mempool.ErrAlreadyKnown
+	}
+
+	// An account that has never been seen has no balance and a nonce of zero.
+	account, err := s.db.Query(from)
+	if err != nil {
+		account = database.Account{AccountID: from}
+	}
+
+	cost, err := tx.Cost()
+	if err != nil {
+		return fmt.Errorf("invalid transaction: %w", err)
+	}
+
+	if account.Balance < cost {
+		return fmt.Errorf("insufficient funds, bal %d, needed %d", account.Balance, cost)
+	}
+
+	// The mempool rejects a nonce the account has already used.
+	if err := s.mempool.Upsert(tx, account.Nonce); err != nil {
+		return err
+	}
+
+	s.seen.add(string(hash))
+
+	s.Worker.SignalShareTx(tx)
+	s.Worker.SignalStartMining()
+
+	return nil
+}
+
+// =============================================================================
+
+// updateMempool removes the mined transactions from the mempool and then
+// tells the mempool the new nonce for every account that sent a transaction
+// in the block, which evicts any transactions that are now stale.
+func (s *State) updateMempool(block database.Block) {
+	if block.MerkleTree == nil {
+		return
+	}
+
+	trans := block.MerkleTree.Values()
+	for _, tx := range trans {
+		if err := s.mempool.Delete(tx); err != nil {
+			s.log.Infow("state", "status", "mempool delete", "tx", tx, "ERROR", err)
+		}
+	}
+
+	for _, tx := range trans {
+		from, err := tx.FromAccount()
+		if err != nil {
+			continue
+		}
+
+		s.mempool.SetNonce(from, s.accountNonce(from))
+	}
+}
+
+// accountNonce returns the nonce of the last transaction applied to the
+// blockchain for the account, which is zero for an unknown account.
+func (s *State) accountNonce(accountID database.AccountID) uint64 {
+	account, err := s.db.Query(accountID)
+	if err != nil {
+		return 0
+	}
+
+	return account.Nonce
+}
+
+// =============================================================================
+
+// seenTxs remembers a bounded number of transaction hashes, forgetting the
+// oldest hash once the limit is reached.
+type seenTxs struct {
+	mu     sync.Mutex
+	max    int
+	hashes map[string]struct{}
+	order  []string
+}
+
+// newSeenTxs constructs a set that remembers up to max hashes.
+func newSeenTxs(max int) *seenTxs {
+	return &seenTxs{
+		max:    max,
+		hashes: make(map[string]struct{}, max),
+	}
+}
+
+// add remembers the hash.
+func (st *seenTxs) add(hash string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, exists := st.hashes[hash]; exists {
+		return
+	}
+
+	if len(st.order) >= st.max {
+		delete(st.hashes, st.order[0])
+		st.order = st.order[1:]
+	}
+
+	st.hashes[hash] = struct{}{}
+	st.order = append(st.order, hash)
+}
+
+// contains returns true when the hash has been seen.
+func (st *seenTxs) contains(hash string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, exists := st.hashes[hash]
+	return exists
+}
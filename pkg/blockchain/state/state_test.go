@@ -0,0 +1,708 @@
+package state_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/genesis"
+	"github.com/sphierex/blockchain/pkg/blockchain/mempool"
+	"github.com/sphierex/blockchain/pkg/blockchain/peer"
+	"github.com/sphierex/blockchain/pkg/blockchain/signature"
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
+	"github.com/sphierex/blockchain/pkg/blockchain/storage/memory"
+	"go.uber.org/zap"
+)
+
+func Test_UpsertWalletTransaction(t *testing.T) {
+	rich := newKey(t)
+	poor := newKey(t)
+	to := accountID(newKey(t))
+
+	st := newState(t, map[*ecdsa.PrivateKey]uint64{rich: 1000, poor: 10})
+
+	table := []struct {
+		testCaseID int
+		key        *ecdsa.PrivateKey
+		chainID    uint16
+		nonce      uint64
+		value      uint64
+		valid      bool
+	}{
+		{testCaseID: 1, key: rich, chainID: 1, nonce: 1, value: 100, valid: true},
+		{testCaseID: 2, key: rich, chainID: 2, nonce: 2, value: 100, valid: false},
+		{testCaseID: 3, key: rich, chainID: 1, nonce: 0, value: 100, valid: false},
+		{testCaseID: 4, key: rich, chainID: 1, nonce: 1, value: 100, valid: false},
+		{testCaseID: 5, key: poor, chainID: 1, nonce: 1, value: 10, valid: false},
+		{testCaseID: 6, key: poor, chainID: 1, nonce: 1, value: 8, valid: true},
+		{testCaseID: 7, key: rich, chainID: 1, nonce: 3, value: 100, valid: true},
+		{testCaseID: 8, key: poor, chainID: 1, nonce: 2, value: math.MaxUint64, valid: false},
+	}
+
+	for _, tt := range table {
+		tx, err := database.NewTx(tt.chainID, tt.nonce, to, tt.value, 1, nil)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+
+		signedTx, err := tx.Sign(tt.key)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+
+		blockTx, err := st.UpsertWalletTransaction(signedTx)
+		if tt.valid && err != nil {
+			t.Errorf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+			continue
+		}
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("[case:%d] error: expected the transaction to be rejected", tt.testCaseID)
+			}
+			continue
+		}
+
+		if blockTx.GasPrice != st.Genesis().GasPrice || blockTx.GasUnits != 1 {
+			t.Errorf("[case:%d] error: expected gas %d x 1, got %d x %d", tt.testCaseID, st.Genesis().GasPrice, blockTx.GasPrice, blockTx.GasUnits)
+		}
+	}
+
+	// The transaction with the nonce gap is held back from mining.
+	if st.MempoolLength() != 3 {
+		t.Errorf("error: expected 3 transactions in the mempool, got %d", st.MempoolLength())
+	}
+	if n := len(st.Mempool()); n != 2 {
+		t.Errorf("error: expected 2 transactions ready to mine, got %d", n)
+	}
+
+	// A transaction without a signature is rejected.
+	var signedTx database.SignedTx
+	signedTx.ChainID = 1
+	signedTx.Nonce = 2
+	signedTx.ToID = to
+	if _, err := st.UpsertWalletTransaction(signedTx); err == nil {
+		t.Errorf("error: expected an unsigned transaction to be rejected")
+	}
+}
+
+func Test_Accounts(t *testing.T) {
+	key := newKey(t)
+
+	st := newState(t, map[*ecdsa.PrivateKey]uint64{key: 1000})
+
+	snapshot := st.Accounts()
+	if snapshot.Latest.Header.Number != 0 {
+		t.Errorf("error: expected latest block 0, got %d", snapshot.Latest.Header.Number)
+	}
+	if snapshot.StateRoot != st.HashState() {
+		t.Errorf("error: expected state root %s, got %s", st.HashState(), snapshot.StateRoot)
+	}
+
+	// The account can be queried no matter the case of the address.
+	lower := database.AccountID(strings.ToLower(string(accountID(key))))
+	account, err := snapshot.Query(lower)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if account.Balance != 1000 {
+		t.Errorf("error: expected balance 1000, got %d", account.Balance)
+	}
+
+	if _, err := snapshot.Query(accountID(newKey(t))); err == nil {
+		t.Errorf("error: expected an unknown account to not exist")
+	}
+
+	// The proofs for a known and an unknown account check out against the
+	// state root.
+	for _, tt := range []struct {
+		accountID database.AccountID
+		included  bool
+	}{
+		{accountID: lower, included: true},
+		{accountID: accountID(newKey(t)), included: false},
+	} {
+		snapshot, proof, err := st.ProveAccount(tt.accountID)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if err := proof.Verify(); err != nil {
+			t.Errorf("error: expected a valid proof for %s: %v", tt.accountID, err)
+		}
+		if proof.Root.String() != snapshot.StateRoot {
+			t.Errorf("error: expected proof root %s, got %s", snapshot.StateRoot, proof.Root)
+		}
+		if proof.Included() != tt.included {
+			t.Errorf("error: expected %s included to be %t", tt.accountID, tt.included)
+		}
+		if tt.included && !bytes.Equal(proof.ValueHash, account.Hash()) {
+			t.Errorf("error: expected the value hash of the account")
+		}
+	}
+}
+
+func Test_ProcessProposedBlock(t *testing.T) {
+	key := newKey(t)
+	to := accountID(newKey(t))
+
+	balances := map[*ecdsa.PrivateKey]uint64{key: 1000}
+	miner := newState(t, balances)
+	node := newState(t, balances)
+
+	// Both nodes learn about the same transactions.
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		tx, err := database.NewTx(1, nonce, to, 10, 1, nil)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		signedTx, err := tx.Sign(key)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		for _, st := range []*state.State{miner, node} {
+			if _, err := st.UpsertWalletTransaction(signedTx); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		}
+	}
+
+	block, err := miner.MineNewBlock(context.Background())
+	if err != nil {
+		t.Fatalf("error: mining block: %v", err)
+	}
+
+	if err := node.ProcessProposedBlock(peer.New("miner"), block); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if node.LatestBlock().Hash() != block.Hash() {
+		t.Errorf("error: expected latest block %s, got %s", block.Hash(), node.LatestBlock().Hash())
+	}
+	if node.HashState() != miner.HashState() {
+		t.Errorf("error: expected state root %s, got %s", miner.HashState(), node.HashState())
+	}
+	if node.MempoolLength() != 0 {
+		t.Errorf("error: expected the mined transactions to leave the mempool, got %d", node.MempoolLength())
+	}
+
+	// The same block can't be applied twice, and a stale block isn't a
+	// reason to sync.
+	worker := &recordWorker{}
+	node.Worker = worker
+	if err := node.ProcessProposedBlock(peer.New("miner"), block); err == nil {
+		t.Errorf("error: expected the block to be rejected the second time")
+	}
+	if len(worker.synced) != 0 {
+		t.Errorf("error: expected no sync for a stale block, got %d", len(worker.synced))
+	}
+}
+
+func Test_UpsertNodeTransaction(t *testing.T) {
+	key := newKey(t)
+	to := accountID(newKey(t))
+
+	balances := map[*ecdsa.PrivateKey]uint64{key: 1000}
+	origin := newState(t, balances)
+	node := newState(t, balances)
+
+	originWorker := &recordWorker{}
+	origin.Worker = originWorker
+	nodeWorker := &recordWorker{}
+	node.Worker = nodeWorker
+
+	tx, err := database.NewTx(1, 1, to, 10, 1, nil)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	signedTx, err := tx.Sign(key)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	blockTx, err := origin.UpsertWalletTransaction(signedTx)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(originWorker.shared) != 1 {
+		t.Fatalf("error: expected the wallet transaction to be shared, got %d", len(originWorker.shared))
+	}
+
+	// The peer accepts and shares the transaction the first time only.
+	if err := node.UpsertNodeTransaction(originWorker.shared[0]); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := node.UpsertNodeTransaction(originWorker.shared[0]); !errors.Is(err, mempool.ErrAlreadyKnown) {
+		t.Errorf("error: expected the transaction to already be known, got %v", err)
+	}
+	if len(nodeWorker.shared) != 1 {
+		t.Errorf("error: expected the transaction to be shared once, got %d", len(nodeWorker.shared))
+	}
+
+	// Sharing the transaction back to where it came from stops there.
+	if err := origin.UpsertNodeTransaction(nodeWorker.shared[0]); !errors.Is(err, mempool.ErrAlreadyKnown) {
+		t.Errorf("error: expected the transaction to already be known, got %v", err)
+	}
+
+	// A peer's timestamp too far from the local clock is rejected.
+	tx, err = database.NewTx(1, 2, to, 10, 1, nil)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	signedTx, err = tx.Sign(key)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for i, timeStamp := range []uint64{0, uint64(time.Now().Add(24 * time.Hour).Unix())} {
+		shared := database.NewBlockTx(signedTx, blockTx.GasPrice, database.OneUnitOfGas)
+		shared.TimeStamp = timeStamp
+		if err := node.UpsertNodeTransaction(shared); err == nil {
+			t.Errorf("[case:%d] error: expected timestamp %d to be rejected", i, timeStamp)
+		}
+	}
+	if trans := node.Mempool(); len(trans) != 1 {
+		t.Errorf("error: expected the rejected transactions to be left out, got %d", len(trans))
+	}
+
+	// A transaction priced for another gas price is rejected.
+	blockTx.Nonce = 2
+	blockTx.GasPrice++
+	if err := node.UpsertNodeTransaction(blockTx); err == nil {
+		t.Errorf("error: expected a transaction with the wrong gas price to be rejected")
+	}
+}
+
+func Test_Sync(t *testing.T) {
+	key := newKey(t)
+	to := accountID(newKey(t))
+
+	balances := map[*ecdsa.PrivateKey]uint64{key: 1000}
+	miner := newState(t, balances)
+
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		mine(t, miner, key, nonce, to, 10)
+	}
+
+	knownPeers := peer.NewPeerSet()
+	knownPeers.Add(serve(t, miner))
+
+	node := newState(t, balances, knownPeers)
+	if !node.IsSyncing() || node.IsSynced() {
+		t.Fatalf("error: expected a node with peers to be syncing")
+	}
+
+	if err := node.Sync(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if node.IsSyncing() || !node.IsSynced() {
+		t.Errorf("error: expected the node to be done syncing")
+	}
+	if node.LatestBlock().Hash() != miner.LatestBlock().Hash() {
+		t.Errorf("error: expected latest block %s, got %s", miner.LatestBlock().Hash(), node.LatestBlock().Hash())
+	}
+	if node.HashState() != miner.HashState() {
+		t.Errorf("error: expected state root %s, got %s", miner.HashState(), node.HashState())
+	}
+
+	// Syncing again when already caught up changes nothing.
+	if err := node.Sync(); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+}
+
+func Test_NetRequestPeerStatuses(t *testing.T) {
+	key := newKey(t)
+	balances := map[*ecdsa.PrivateKey]uint64{key: 1000}
+
+	live := serve(t, newState(t, balances))
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	dead := peer.New(strings.TrimPrefix(srv.URL, "http://"))
+
+	knownPeers := peer.NewPeerSet()
+	knownPeers.Add(live)
+	knownPeers.Add(dead)
+
+	node := newState(t, balances, knownPeers)
+
+	// The unreachable peer is removed once it fails too many times in a row.
+	for i := 1; i <= 3; i++ {
+		statuses := node.NetRequestPeerStatuses()
+		if _, exists := statuses[live]; !exists || len(statuses) != 1 {
+			t.Fatalf("[poll:%d] error: expected only the live peer to respond, got %d statuses", i, len(statuses))
+		}
+	}
+
+	peers := node.KnownExternalPeers()
+	if len(peers) != 1 || peers[0] != live {
+		t.Errorf("error: expected only the live peer to be known, got %v", peers)
+	}
+}
+
+func Test_SyncWithSynced(t *testing.T) {
+	key := newKey(t)
+	to := accountID(newKey(t))
+
+	balances := map[*ecdsa.PrivateKey]uint64{key: 1000}
+	miner := newState(t, balances)
+	mine(t, miner, key, 1, to, 10)
+
+	node := newState(t, balances)
+	if node.IsSyncing() || !node.IsSynced() {
+		t.Fatalf("error: expected a node without peers to be synced")
+	}
+
+	// Record what the node reports while it downloads the blocks.
+	var syncing, synced bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/node/status", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(miner.Status())
+	})
+	mux.HandleFunc("/v1/node/block/list/{from}/{to}", func(w http.ResponseWriter, r *http.Request) {
+		syncing, synced = node.IsSyncing(), node.IsSynced()
+		blocks, _ := miner.QueryBlocks(1, 1)
+		_ = json.NewEncoder(w).Encode(blocks)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Syncing with a peer holds back mining but the node stays synced.
+	if err := node.SyncWith(peer.New(strings.TrimPrefix(srv.URL, "http://"))); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !syncing || !synced {
+		t.Errorf("error: expected the node to be syncing and synced, got %t %t", syncing, synced)
+	}
+	if node.IsSyncing() || !node.IsSynced() {
+		t.Errorf("error: expected the node to be done syncing")
+	}
+}
+
+func Test_SyncFork(t *testing.T) {
+	key := newKey(t)
+	other := newKey(t)
+	to := accountID(newKey(t))
+
+	balances := map[*ecdsa.PrivateKey]uint64{key: 1000, other: 1000}
+	miner := newState(t, balances)
+	node := newState(t, balances)
+
+	worker := &recordWorker{}
+	node.Worker = worker
+
+	// Both nodes mine their own first block, then the miner gets ahead.
+	mine(t, miner, other, 1, to, 10)
+	mine(t, node, key, 1, to, 20)
+	block := mine(t, miner, other, 2, to, 10)
+
+	pr := serve(t, miner)
+
+	// The block from the miner doesn't build on the node's latest block,
+	// so the node asks to sync with the miner.
+	if err := node.ProcessProposedBlock(pr, block); !errors.Is(err, database.ErrChainForked) {
+		t.Fatalf("error: expected the chain to be forked, got %v", err)
+	}
+	if len(worker.synced) != 1 || worker.synced[0] != pr {
+		t.Fatalf("error: expected a sync with %s, got %v", pr.Host, worker.synced)
+	}
+
+	// The miner's chain has more work and replaces the node's chain.
+	if err := node.SyncWith(pr); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if node.LatestBlock().Hash() != miner.LatestBlock().Hash() {
+		t.Errorf("error: expected latest block %s, got %s", miner.LatestBlock().Hash(), node.LatestBlock().Hash())
+	}
+	if node.HashState() != miner.HashState() {
+		t.Errorf("error: expected state root %s, got %s", miner.HashState(), node.HashState())
+	}
+	if node.IsSyncing() {
+		t.Errorf("error: expected the node to be done syncing")
+	}
+
+	// The transaction from the dropped block is back in the mempool, and
+	// the wallet can carry on from it.
+	trans := node.Mempool()
+	if len(trans) != 1 || trans[0].Nonce != 1 || trans[0].Value != 20 {
+		t.Fatalf("error: expected the dropped transaction back in the mempool, got %v", trans)
+	}
+	tx, err := database.NewTx(1, 2, to, 10, 1, nil)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	signedTx, err := tx.Sign(key)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := node.UpsertWalletTransaction(signedTx); err != nil {
+		t.Errorf("error: expected the next nonce to be accepted, got %v", err)
+	}
+
+	// A block further ahead than the next block also asks for a sync.
+	behind := newState(t, balances)
+	behind.Worker = worker
+	if err := behind.ProcessProposedBlock(pr, block); !errors.Is(err, database.ErrBlockAhead) {
+		t.Errorf("error: expected the block to be ahead, got %v", err)
+	}
+	if len(worker.synced) != 2 {
+		t.Errorf("error: expected a second sync, got %d", len(worker.synced))
+	}
+}
+
+func Test_SyncForkInvalid(t *testing.T) {
+	key := newKey(t)
+	other := newKey(t)
+	to := accountID(newKey(t))
+
+	balances := map[*ecdsa.PrivateKey]uint64{key: 1000, other: 1000}
+
+	// A block that claims to follow a block nobody has.
+	badPrev := database.BlockData{
+		Header: database.BlockHeader{
+			Number:        4,
+			PrevBlockHash: "0xdead",
+			TimeStamp:     uint64(time.Now().Unix()),
+			Difficulty:    1,
+			TransRoot:     signature.ZeroHash,
+		},
+	}
+
+	// A forked chain that is longer, but its last block is mined with the
+	// wrong state root.
+	fork := newState(t, balances)
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		mine(t, fork, other, nonce, to, 10)
+	}
+	badRoot, _, err := database.POW(context.Background(), database.POWArgs{
+		Header: database.BlockHeader{
+			Number:        4,
+			PrevBlockHash: fork.LatestBlock().Hash(),
+			TimeStamp:     fork.LatestBlock().Header.TimeStamp,
+			BeneficiaryID: to,
+			Difficulty:    1,
+			MiningReward:  700,
+			StateRoot:     "0xbad",
+		},
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	forkBlocks, err := fork.QueryBlocks(1, 3)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	table := []struct {
+		testCaseID int
+		blocks     func(node *state.State) []database.BlockData
+	}{
+		{
+			testCaseID: 1,
+			blocks: func(node *state.State) []database.BlockData {
+				blocks, err := node.QueryBlocks(1, 3)
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				return append(blocks, badPrev)
+			},
+		},
+		{
+			testCaseID: 2,
+			blocks: func(node *state.State) []database.BlockData {
+				return append(forkBlocks, database.ToBlockData(badRoot))
+			},
+		},
+	}
+
+	for _, tt := range table {
+		node := newState(t, balances)
+		node.Worker = &recordWorker{}
+		for nonce := uint64(1); nonce <= 3; nonce++ {
+			mine(t, node, key, nonce, to, 10)
+		}
+		latest := node.LatestBlock()
+		stateRoot := node.HashState()
+
+		blocks := tt.blocks(node)
+		pr := serveBlocks(t, 1000, blocks)
+
+		block, err := database.ToBlock(blocks[len(blocks)-1])
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", tt.testCaseID, err)
+		}
+		if err := node.ProcessProposedBlock(pr, block); err == nil {
+			t.Fatalf("[case:%d] error: expected the proposed block to be rejected", tt.testCaseID)
+		}
+		if err := node.SyncWith(pr); err == nil {
+			t.Errorf("[case:%d] error: expected the sync to fail", tt.testCaseID)
+		}
+
+		if node.LatestBlock().Hash() != latest.Hash() {
+			t.Errorf("[case:%d] error: expected latest block %s, got %s", tt.testCaseID, latest.Hash(), node.LatestBlock().Hash())
+		}
+		if node.HashState() != stateRoot {
+			t.Errorf("[case:%d] error: expected state root %s, got %s", tt.testCaseID, stateRoot, node.HashState())
+		}
+		if _, err := node.QueryBlock(1); err != nil {
+			t.Errorf("[case:%d] error: expected block 1 to survive, got %v", tt.testCaseID, err)
+		}
+	}
+}
+
+// =============================================================================
+
+// recordWorker records the transactions the state asks to be shared and
+// the peers it asks to sync with.
+type recordWorker struct {
+	shared []database.BlockTx
+	synced []peer.Peer
+}
+
+func (*recordWorker) Shutdown()           {}
+func (*recordWorker) SignalStartMining()  {}
+func (*recordWorker) SignalCancelMining() {}
+
+func (w *recordWorker) SignalShareTx(tx database.BlockTx) {
+	w.shared = append(w.shared, tx)
+}
+
+func (w *recordWorker) SignalSync(pr peer.Peer) {
+	w.synced = append(w.synced, pr)
+}
+
+// mine submits a transaction to the state and mines it into a new block.
+func mine(t *testing.T, st *state.State, key *ecdsa.PrivateKey, nonce uint64, to database.AccountID, value uint64) database.Block {
+	t.Helper()
+
+	tx, err := database.NewTx(1, nonce, to, value, 1, nil)
+	if err != nil {
+		t.Fatalf("error: constructing tx: %v", err)
+	}
+
+	signedTx, err := tx.Sign(key)
+	if err != nil {
+		t.Fatalf("error: signing tx: %v", err)
+	}
+
+	if _, err := st.UpsertWalletTransaction(signedTx); err != nil {
+		t.Fatalf("error: submitting tx: %v", err)
+	}
+
+	block, err := st.MineNewBlock(context.Background())
+	if err != nil {
+		t.Fatalf("error: mining block: %v", err)
+	}
+
+	return block
+}
+
+// serve exposes the private endpoints a syncing node needs from the state
+// and returns the peer to reach them on.
+func serve(t *testing.T, st *state.State) peer.Peer {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/node/status", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(st.Status())
+	})
+	mux.HandleFunc("/v1/node/block/list/{from}/{to}", func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.ParseUint(r.PathValue("from"), 10, 64)
+		to, _ := strconv.ParseUint(r.PathValue("to"), 10, 64)
+		blocks, err := st.QueryBlocks(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(blocks)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return peer.New(strings.TrimPrefix(srv.URL, "http://"))
+}
+
+// serveBlocks exposes a peer that claims to have the specified latest block
+// but only serves the blocks it's given.
+func serveBlocks(t *testing.T, latest uint64, blocks []database.BlockData) peer.Peer {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/node/status", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(peer.PeerStatus{LatestBlockNumber: latest})
+	})
+	mux.HandleFunc("/v1/node/block/list/{from}/{to}", func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.ParseUint(r.PathValue("from"), 10, 64)
+		to, _ := strconv.ParseUint(r.PathValue("to"), 10, 64)
+		list := []database.BlockData{}
+		for _, blockData := range blocks {
+			if blockData.Header.Number >= from && blockData.Header.Number <= to {
+				list = append(list, blockData)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return peer.New(strings.TrimPrefix(srv.URL, "http://"))
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("error: generating key: %v", err)
+	}
+
+	return privateKey
+}
+
+func accountID(privateKey *ecdsa.PrivateKey) database.AccountID {
+	return database.AccountID(crypto.PubkeyToAddress(privateKey.PublicKey).String())
+}
+
+func newState(t *testing.T, balances map[*ecdsa.PrivateKey]uint64, knownPeers ...*peer.PeerSet) *state.State {
+	t.Helper()
+
+	gen := genesis.Genesis{
+		ChainID:       1,
+		TransPerBlock: 10,
+		Difficulty:    1,
+		MiningReward:  700,
+		GasPrice:      1,
+		Balances:      make(map[string]uint64),
+	}
+
+	for privateKey, balance := range balances {
+		gen.Balances[string(accountID(privateKey))] = balance
+	}
+
+	cfg := state.Config{
+		BeneficiaryID: accountID(newKey(t)),
+		Genesis:       gen,
+		Storage:       memory.New(),
+		Log:           zap.NewNop().Sugar(),
+	}
+	if len(knownPeers) > 0 {
+		cfg.KnownPeers = knownPeers[0]
+	}
+
+	st, err := state.New(cfg)
+	if err != nil {
+		t.Fatalf("error: constructing state: %v", err)
+	}
+
+	return st
+}
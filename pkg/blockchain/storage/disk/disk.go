@@ -0,0 +1,172 @@
+// Package disk implements the ability to read and write blocks to disk
+// writing each block to a separate JSON file.
+package disk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// Disk represents the serialization implementation for reading and storing
+// blocks in their own separate files on disk. This implements the
+// database.Storage interface.
+type Disk struct {
+	dbPath string
+}
+
+// New constructs a Disk value for use, creating the directory if needed.
+func New(dbPath string) (*Disk, error) {
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Disk{dbPath: dbPath}, nil
+}
+
+// Close in this implementation has nothing to do since a new file is
+// written to disk for each new block and then immediately closed.
+func (d *Disk) Close() error {
+	return nil
+}
+
+// Write takes the specified block and stores it on disk in a file labeled
+// with the block number. The block is written to a temp file first and then
+// renamed into place, so a crash never leaves a partially written block.
+func (d *Disk) Write(blockData database.BlockData) error {
+	data, err := json.MarshalIndent(blockData, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(d.dbPath, "*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(f, data); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), d.getPath(blockData.Header.Number)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// GetBlock searches the blockchain on disk to locate and return the
+// contents of the specified block by number.
+func (d *Disk) GetBlock(num uint64) (database.BlockData, error) {
+	data, err := os.ReadFile(d.getPath(num))
+	if err != nil {
+		return database.BlockData{}, err
+	}
+
+	var blockData database.BlockData
+	if err := json.Unmarshal(data, &blockData); err != nil {
+		return database.BlockData{}, fmt.Errorf("block %d: %w", num, err)
+	}
+
+	return blockData, nil
+}
+
+// ForEach returns an iterator to walk through all the blocks
+// starting with block number 1.
+func (d *Disk) ForEach() database.Iterator {
+	return &diskIterator{storage: d}
+}
+
+// Reset will clear out the blockchain on disk.
+func (d *Disk) Reset() error {
+	if err := os.RemoveAll(d.dbPath); err != nil {
+		return err
+	}
+
+	return os.MkdirAll(d.dbPath, 0755)
+}
+
+// Truncate removes every block after the specified block number from disk.
+// The blocks are removed from the last one back, so a crash part way through
+// never leaves a gap in the chain on disk.
+func (d *Disk) Truncate(num uint64) error {
+	last := num
+	for {
+		_, err := os.Stat(d.getPath(last + 1))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		last++
+	}
+
+	for n := last; n > num; n-- {
+		if err := os.Remove(d.getPath(n)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFile writes the data to the file, flushes it to disk and closes it.
+func writeFile(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// getPath forms the path to the specified block.
+func (d *Disk) getPath(blockNum uint64) string {
+	name := strconv.FormatUint(blockNum, 10)
+	return filepath.Join(d.dbPath, name+".json")
+}
+
+// =============================================================================
+
+// diskIterator represents the iteration implementation for walking
+// through and reading blocks on disk. This implements the database
+// Iterator interface.
+type diskIterator struct {
+	storage *Disk  // Access to the storage API.
+	current uint64 // Currently loaded block.
+	eoc     bool   // Represents the end of the chain.
+}
+
+// Next retrieves the next block from disk.
+func (di *diskIterator) Next() (database.BlockData, error) {
+	if di.eoc {
+		return database.BlockData{}, errors.New("end of chain")
+	}
+
+	di.current++
+	blockData, err := di.storage.GetBlock(di.current)
+	if errors.Is(err, fs.ErrNotExist) {
+		di.eoc = true
+	}
+
+	return blockData, err
+}
+
+// Done returns the end of chain value.
+func (di *diskIterator) Done() bool {
+	return di.eoc
+}
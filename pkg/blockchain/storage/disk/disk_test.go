@@ -0,0 +1,94 @@
+package disk_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/storage/disk"
+)
+
+func Test_Disk(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "blocks")
+
+	d, err := disk.New(dbPath)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer d.Close()
+
+	for num := uint64(1); num <= 3; num++ {
+		blockData := database.BlockData{
+			Hash:   "0x" + strconv.FormatUint(num, 10),
+			Header: database.BlockHeader{Number: num},
+		}
+		if err := d.Write(blockData); err != nil {
+			t.Fatalf("[block:%d] error: unexpected error: %v", num, err)
+		}
+	}
+
+	// Only the block files are left once the writes are renamed into place.
+	entries, err := os.ReadDir(dbPath)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("error: expected 3 files, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			t.Errorf("error: unexpected file %s", entry.Name())
+		}
+	}
+
+	blockData, err := d.GetBlock(2)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if blockData.Header.Number != 2 || blockData.Hash != "0x2" {
+		t.Errorf("error: expected block 2, got %d %s", blockData.Header.Number, blockData.Hash)
+	}
+
+	// The iterator walks the blocks in order and stops at the end of chain.
+	iter := d.ForEach()
+	for num := uint64(1); num <= 3; num++ {
+		blockData, err := iter.Next()
+		if err != nil {
+			t.Fatalf("[block:%d] error: unexpected error: %v", num, err)
+		}
+		if blockData.Header.Number != num {
+			t.Errorf("[block:%d] error: got block %d", num, blockData.Header.Number)
+		}
+	}
+	if _, err := iter.Next(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error: expected the end of chain, got %v", err)
+	}
+	if !iter.Done() {
+		t.Errorf("error: expected the iterator to be done")
+	}
+
+	// Truncating keeps the blocks up to the specified block.
+	if err := d.Truncate(2); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := d.GetBlock(2); err != nil {
+		t.Errorf("error: expected block 2 to be kept, got %v", err)
+	}
+	if _, err := d.GetBlock(3); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error: expected block 3 to be removed, got %v", err)
+	}
+
+	if err := d.Reset(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := d.GetBlock(1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error: expected no blocks after reset, got %v", err)
+	}
+	if _, err := d.ForEach().Next(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error: expected an empty chain after reset, got %v", err)
+	}
+}
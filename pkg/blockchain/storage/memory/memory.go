@@ -0,0 +1,113 @@
+// Package memory implements the ability to read and write blocks to memory.
+// This is mostly useful for testing since nothing survives a restart.
+package memory
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+)
+
+// Memory represents the storage implementation for reading and storing
+// blocks in memory. This implements the database.Storage interface.
+type Memory struct {
+	mu     sync.RWMutex
+	blocks map[uint64]database.BlockData
+}
+
+// New constructs a Memory value for use.
+func New() *Memory {
+	return &Memory{
+		blocks: make(map[uint64]database.BlockData),
+	}
+}
+
+// Close in this implementation has nothing to do.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Write takes the specified block and stores it in memory.
+func (m *Memory) Write(blockData database.BlockData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks[blockData.Header.Number] = blockData
+
+	return nil
+}
+
+// GetBlock returns the specified block by number.
+func (m *Memory) GetBlock(num uint64) (database.BlockData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	blockData, exists := m.blocks[num]
+	if !exists {
+		return database.BlockData{}, errors.New("block does not exist")
+	}
+
+	return blockData, nil
+}
+
+// ForEach returns an iterator to walk through all the blocks
+// starting with block number 1.
+func (m *Memory) ForEach() database.Iterator {
+	return &memoryIterator{storage: m}
+}
+
+// Reset will clear out the blockchain in memory.
+func (m *Memory) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks = make(map[uint64]database.BlockData)
+
+	return nil
+}
+
+// Truncate removes every block after the specified block number.
+func (m *Memory) Truncate(num uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for n := range m.blocks {
+		if n > num {
+			delete(m.blocks, n)
+		}
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// memoryIterator represents the iteration implementation for walking
+// through the blocks held in memory. This implements the database
+// Iterator interface.
+type memoryIterator struct {
+	storage *Memory // Access to the storage API.
+	current uint64  // Currently loaded block.
+	eoc     bool    // Represents the end of the chain.
+}
+
+// Next retrieves the next block from memory.
+func (mi *memoryIterator) Next() (database.BlockData, error) {
+	if mi.eoc {
+		return database.BlockData{}, errors.New("end of chain")
+	}
+
+	mi.current++
+	blockData, err := mi.storage.GetBlock(mi.current)
+	if err != nil {
+		mi.eoc = true
+	}
+
+	return blockData, err
+}
+
+// Done returns the end of chain value.
+func (mi *memoryIterator) Done() bool {
+	return mi.eoc
+}
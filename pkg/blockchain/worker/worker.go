@@ -0,0 +1,386 @@
+// Package worker implements the background mining, peer updates and
+// transaction sharing for the blockchain.
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sphierex/blockchain/pkg/blockchain/database"
+	"github.com/sphierex/blockchain/pkg/blockchain/peer"
+	"github.com/sphierex/blockchain/pkg/blockchain/state"
+	"go.uber.org/zap"
+)
+
+// peerUpdateInterval represents the interval of finding new peer nodes
+// and updating the blockchain on disk with missing blocks.
+const peerUpdateInterval = time.Minute
+
+// maxTxShareRequests represents the max number of pending tx network share
+// requests that can be outstanding before share requests are dropped.
+const maxTxShareRequests = 100
+
+// txShareInterval represents how long newly accepted transactions are
+// batched before they are shared with the known peers.
+const txShareInterval = 250 * time.Millisecond
+
+// maxTxShareBatch represents the number of transactions that causes a batch
+// to be shared straight away.
+const maxTxShareBatch = 50
+
+// Worker manages the POW workflows for the blockchain.
+type Worker struct {
+	state        *state.State
+	log          *zap.SugaredLogger
+	wg           sync.WaitGroup
+	ticker       *time.Ticker
+	shut         chan struct{}
+	startMining  chan bool
+	cancelMining chan bool
+	txSharing    chan database.BlockTx
+	syncRequests chan peer.Peer
+}
+
+// Run creates a worker, registers the worker with the state package, and
+// starts up all the background processes.
+func Run(st *state.State, log *zap.SugaredLogger) {
+	w := Worker{
+		state:        st,
+		log:          log,
+		ticker:       time.NewTicker(peerUpdateInterval),
+		shut:         make(chan struct{}),
+		startMining:  make(chan bool, 1),
+		cancelMining: make(chan bool, 1),
+		txSharing:    make(chan database.BlockTx, maxTxShareRequests),
+		syncRequests: make(chan peer.Peer, 1),
+	}
+
+	// Register this worker with the state package.
+	st.Worker = &w
+
+	// Load the set of operations we need to run.
+	operations := []func(){
+		w.peerOperations,
+		w.powOperations,
+		w.shareTxOperations,
+	}
+
+	// Set waitgroup to match the number of G's we need for the set
+	// of operations we have.
+	g := len(operations)
+	w.wg.Add(g)
+
+	// We don't want to return until we know all the G's are up and running.
+	hasStarted := make(chan bool)
+
+	// Start all the operational G's.
+	for _, op := range operations {
+		go func(op func()) {
+			defer w.wg.Done()
+			hasStarted <- true
+			op()
+		}(op)
+	}
+
+	// Wait for the G's to report they are running.
+	for i := 0; i < g; i++ {
+		<-hasStarted
+	}
+}
+
+// =============================================================================
+// These methods implement the state.Worker interface.
+
+// Shutdown terminates the goroutine performing work.
+func (w *Worker) Shutdown() {
+	w.log.Infow("worker", "status", "shutdown started")
+	defer w.log.Infow("worker", "status", "shutdown completed")
+
+	w.log.Infow("worker", "status", "stop ticker")
+	w.ticker.Stop()
+
+	w.log.Infow("worker", "status", "signal cancel mining")
+	w.SignalCancelMining()
+
+	w.log.Infow("worker", "status", "terminate worker goroutines")
+	close(w.shut)
+	w.wg.Wait()
+}
+
+// SignalStartMining starts a mining operation. If there is already a signal
+// pending in the channel, just return since a mining operation will start.
+func (w *Worker) SignalStartMining() {
+	select {
+	case w.startMining <- true:
+	default:
+	}
+	w.log.Infow("worker", "status", "mining signaled")
+}
+
+// SignalCancelMining signals the G executing the runPowOperation function
+// to stop immediately.
+func (w *Worker) SignalCancelMining() {
+	select {
+	case w.cancelMining <- true:
+	default:
+	}
+	w.log.Infow("worker", "status", "cancel mining signaled")
+}
+
+// SignalShareTx queues the transaction to be shared with the known peers.
+// If the queue is full the request is dropped, since the transaction will
+// still be mined by this node.
+func (w *Worker) SignalShareTx(tx database.BlockTx) {
+	select {
+	case w.txSharing <- tx:
+		w.log.Infow("worker", "status", "share tx signaled")
+	default:
+		w.log.Infow("worker", "status", "dropping tx share request")
+	}
+}
+
+// SignalSync requests a sync with the peer. If a sync is already pending the
+// request is dropped, since a peer that is still ahead will propose another
+// block.
+func (w *Worker) SignalSync(pr peer.Peer) {
+	select {
+	case w.syncRequests <- pr:
+		w.log.Infow("worker", "status", "sync signaled", "peer", pr.Host)
+	default:
+		w.log.Infow("worker", "status", "dropping sync request", "peer", pr.Host)
+	}
+}
+
+// =============================================================================
+
+// peerOperations handles finding new peers and keeping the blockchain in
+// sync with them.
+func (w *Worker) peerOperations() {
+	w.log.Infow("worker", "status", "peerOperations: G started")
+	defer w.log.Infow("worker", "status", "peerOperations: G completed")
+
+	// On startup talk to all the known peers to find any new ones, catch
+	// up with the blockchain and let them know this node is available.
+	w.runPeersOperation()
+	w.runSyncOperation()
+	w.state.NetSendNodeAvailableToPeers()
+
+	for {
+		select {
+		case <-w.ticker.C:
+			if !w.isShutdown() {
+				w.runPeersOperation()
+				w.runSyncOperation()
+			}
+		case pr := <-w.syncRequests:
+			if !w.isShutdown() {
+				w.runSyncWithOperation(pr)
+			}
+		case <-w.shut:
+			w.log.Infow("worker", "status", "peerOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// runPeersOperation requests the status of every known peer and adds the
+// peers they know about that this node doesn't know yet. Peers that keep
+// failing to respond are removed by the state.
+func (w *Worker) runPeersOperation() {
+	w.log.Infow("worker", "status", "runPeersOperation: started")
+	defer w.log.Infow("worker", "status", "runPeersOperation: completed")
+
+	for _, peerStatus := range w.state.NetRequestPeerStatuses() {
+		for _, known := range peerStatus.KnownPeers {
+			added, err := w.state.AddKnownPeer(known)
+			if err != nil {
+				w.log.Infow("worker", "status", "runPeersOperation: ERROR", "host", known.Host, "ERROR", err)
+				return
+			}
+			if added {
+				w.log.Infow("worker", "status", "runPeersOperation: adding peer", "host", known.Host)
+			}
+		}
+	}
+}
+
+// runSyncOperation catches the blockchain up with the known peers. Mining
+// is held back until the sync is complete.
+func (w *Worker) runSyncOperation() {
+	w.log.Infow("worker", "status", "runSyncOperation: started")
+	defer w.log.Infow("worker", "status", "runSyncOperation: completed")
+
+	if err := w.state.Sync(); err != nil {
+		w.log.Infow("worker", "status", "runSyncOperation: ERROR", "ERROR", err)
+	}
+
+	if w.state.MempoolHasReady() {
+		w.SignalStartMining()
+	}
+}
+
+// runSyncWithOperation catches the blockchain up with the peer that proposed
+// a block this node couldn't apply.
+func (w *Worker) runSyncWithOperation(pr peer.Peer) {
+	w.log.Infow("worker", "status", "runSyncWithOperation: started", "peer", pr.Host)
+	defer w.log.Infow("worker", "status", "runSyncWithOperation: completed", "peer", pr.Host)
+
+	if err := w.state.SyncWith(pr); err != nil {
+		w.log.Infow("worker", "status", "runSyncWithOperation: ERROR", "peer", pr.Host, "ERROR", err)
+	}
+
+	if w.state.MempoolHasReady() {
+		w.SignalStartMining()
+	}
+}
+
+// =============================================================================
+
+// powOperations handles mining.
+func (w *Worker) powOperations() {
+	w.log.Infow("worker", "status", "powOperations: G started")
+	defer w.log.Infow("worker", "status", "powOperations: G completed")
+
+	for {
+		select {
+		case <-w.startMining:
+			if !w.isShutdown() {
+				w.runPowOperation()
+			}
+		case <-w.shut:
+			w.log.Infow("worker", "status", "powOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// runPowOperation takes all the transactions from the mempool and writes a
+// new block to the database.
+func (w *Worker) runPowOperation() {
+	w.log.Infow("worker", "status", "runPowOperation: MINING: started")
+	defer w.log.Infow("worker", "status", "runPowOperation: MINING: completed")
+
+	// Blocks mined before the node has caught up would be rejected.
+	if w.state.IsSyncing() {
+		w.log.Infow("worker", "status", "runPowOperation: MINING: waiting for sync")
+		return
+	}
+
+	// Make sure there are transactions ready to be mined.
+	if !w.state.MempoolHasReady() {
+		w.log.Infow("worker", "status", "runPowOperation: MINING: no transactions to mine")
+		return
+	}
+
+	// Drain the cancel mining channel before starting.
+	select {
+	case <-w.cancelMining:
+		w.log.Infow("worker", "status", "runPowOperation: MINING: drained cancel channel")
+	default:
+	}
+
+	// Create a context so mining can be cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Can't return from this function until these G's are complete.
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// This G exists to cancel the mining operation.
+	go func() {
+		defer func() {
+			cancel()
+			wg.Done()
+		}()
+
+		select {
+		case <-w.cancelMining:
+			w.log.Infow("worker", "status", "runPowOperation: MINING: CANCEL: requested")
+		case <-ctx.Done():
+		}
+	}()
+
+	// This G is performing the mining.
+	var mined bool
+	go func() {
+		defer func() {
+			cancel()
+			wg.Done()
+		}()
+
+		block, err := w.state.MineNewBlock(ctx)
+		if err != nil {
+			switch {
+			case errors.Is(err, state.ErrNoTransactions):
+				w.log.Infow("worker", "status", "runPowOperation: MINING: WARNING: no transactions in mempool")
+			case ctx.Err() != nil:
+				w.log.Infow("worker", "status", "runPowOperation: MINING: CANCEL: complete")
+			default:
+				w.log.Infow("worker", "status", "runPowOperation: MINING: ERROR", "ERROR", err)
+			}
+			return
+		}
+
+		mined = true
+		w.log.Infow("worker", "status", "runPowOperation: MINING: mined new block", "block", block.Header.Number, "hash", block.Hash())
+
+		// Share the block with the network so the peers can stop mining
+		// at this height.
+		w.state.NetSendBlockToPeers(block)
+	}()
+
+	// Wait for both G's to terminate.
+	wg.Wait()
+
+	// Keep mining while there are transactions left over. When nothing was
+	// mined the remaining transactions can't be applied yet, so wait for the
+	// next signal instead of spinning.
+	if mined && w.state.MempoolHasReady() {
+		w.SignalStartMining()
+	}
+}
+
+// shareTxOperations handles sharing new transactions with the known peers.
+// Transactions are collected into a batch that is sent once it's full or
+// the share interval has passed.
+func (w *Worker) shareTxOperations() {
+	w.log.Infow("worker", "status", "shareTxOperations: G started")
+	defer w.log.Infow("worker", "status", "shareTxOperations: G completed")
+
+	ticker := time.NewTicker(txShareInterval)
+	defer ticker.Stop()
+
+	var batch []database.BlockTx
+
+	for {
+		select {
+		case tx := <-w.txSharing:
+			batch = append(batch, tx)
+			if len(batch) >= maxTxShareBatch {
+				w.state.NetSendTxsToPeers(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.state.NetSendTxsToPeers(batch)
+				batch = nil
+			}
+		case <-w.shut:
+			w.log.Infow("worker", "status", "shareTxOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// isShutdown is used to test if a shutdown has been signaled.
+func (w *Worker) isShutdown() bool {
+	select {
+	case <-w.shut:
+		return true
+	default:
+		return false
+	}
+}